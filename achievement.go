@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/jinzhu/gorm"
+)
+
+// Triggers of the achievement rules.
+const (
+	// TriggerAnswer is an answer to the current question.
+	TriggerAnswer = "answer"
+	// TriggerRound is the end of a scored question, for the users who answered and its author.
+	TriggerRound = "round"
+	// TriggerMessage is a message posted on the wall.
+	TriggerMessage = "message"
+)
+
+// Stats compared to the thresholds of the achievement rules.
+const (
+	StatAnswers      = "answers"
+	StatRightAnswers = "right_answers"
+	StatStreak       = "streak"
+	StatPoints       = "points"
+	// StatStumpers counts the scored questions of the user answered by nobody right.
+	StatStumpers = "stumpers"
+	StatMessages = "messages"
+)
+
+var (
+	errAchievementUnlocked = errors.New("Achievement already unlocked")
+
+	// slackAchievementsChannel is the channel where the unlocked achievements are announced.
+	slackAchievementsChannel = os.Getenv("SLACK_ACHIEVEMENTS_CHANNEL")
+
+	// achievementRules are the achievements. A user unlocks one when the stat of the rule
+	// reaches its threshold, which is checked on its trigger.
+	achievementRules = []AchievementRule{
+		{Code: "first_answer", Name: "Hello TV", Description: "Answer a question.", On: TriggerAnswer, Stat: StatAnswers, Threshold: 1},
+		{Code: "first_right_answer", Name: "Bright spark", Description: "Get an answer right.", On: TriggerRound, Stat: StatRightAnswers, Threshold: 1},
+		{Code: "streak_10", Name: "On fire", Description: "Get 10 answers right in a row.", On: TriggerRound, Stat: StatStreak, Threshold: 10},
+		{Code: "points_1000", Name: "Big brain", Description: "Score 1000 points.", On: TriggerRound, Stat: StatPoints, Threshold: 1000},
+		{Code: "stumper", Name: "Stumper", Description: "Ask a question nobody got right.", On: TriggerRound, Stat: StatStumpers, Threshold: 1},
+		{Code: "messages_100", Name: "Chatterbox", Description: "Post 100 messages on the wall.", On: TriggerMessage, Stat: StatMessages, Threshold: 100},
+	}
+
+	// achievementStats returns the stats of a user.
+	achievementStats = map[string]func(tx Store, user *User) (int, error){
+		StatAnswers: func(tx Store, user *User) (int, error) { return tx.CountAnswerEntriesByUserID(user.ID) },
+		StatRightAnswers: func(tx Store, user *User) (int, error) {
+			return tx.CountPointEvents(user.ID, ReasonRightAnswer)
+		},
+		StatStreak:   func(tx Store, user *User) (int, error) { return int(user.Streak), nil },
+		StatPoints:   func(tx Store, user *User) (int, error) { return int(user.Points), nil },
+		StatStumpers: func(tx Store, user *User) (int, error) { return tx.CountStumpers(user.ID) },
+		StatMessages: func(tx Store, user *User) (int, error) { return tx.CountMessagesByUserID(user.ID) },
+	}
+)
+
+// AchievementRule declares an achievement and when it is unlocked.
+type AchievementRule struct {
+	Code        string
+	Name        string
+	Description string
+	On          string
+	Stat        string
+	Threshold   int
+}
+
+// Achievement is a badge the users can unlock, as declared by its rule.
+type Achievement struct {
+	gorm.Model
+	Code        string `sql:"unique"`
+	Name        string
+	Description string
+}
+
+// UserAchievement is an achievement unlocked by a user.
+type UserAchievement struct {
+	gorm.Model
+	UserID        uint
+	AchievementID uint
+}
+
+// UnlockedAchievement is an achievement with the time the user unlocked it.
+type UnlockedAchievement struct {
+	Achievement *Achievement
+	UnlockedAt  time.Time
+}
+
+// getUserAchievements returns the achievements of a user, the first unlocked first.
+func getUserAchievements(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	id, err := strconv.ParseUint(params["user_id"], 10, 64)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, errInvalidUserID)
+		return
+	}
+	if _, err := GetUser(uint(id)); err != nil {
+		renderJSON(w, http.StatusNotFound, errUserNotFound)
+		return
+	}
+	unlocked, err := db.GetUserAchievements(uint(id))
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	achievements := make([]UnlockedAchievement, 0, len(unlocked))
+	for _, userAchievement := range unlocked {
+		achievement, err := db.GetAchievement(userAchievement.AchievementID)
+		if err != nil {
+			renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+		achievements = append(achievements, UnlockedAchievement{Achievement: achievement, UnlockedAt: userAchievement.CreatedAt})
+	}
+	renderJSON(w, http.StatusOK, achievements)
+}
+
+// checkAchievements unlocks the achievements of the trigger reached by the users, each in
+// its own transaction, and announces them in the background. The errors are logged, not to
+// fail what triggered them.
+func checkAchievements(trigger string, userIDs ...uint) {
+	for _, userID := range userIDs {
+		var user *User
+		var unlocked []UnlockedAchievement
+		err := db.Transaction(func(tx Store) error {
+			var err error
+			if user, err = tx.GetUser(userID); err != nil {
+				return err
+			}
+			unlocked, err = unlockAchievements(tx, trigger, user)
+			return err
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"trigger": trigger,
+				"user_id": userID,
+				"err":     err,
+			}).Error("Can't check achievements")
+			continue
+		}
+		if len(unlocked) > 0 && slackAchievementsChannel != "" {
+			go announceAchievements(slackAchievementsChannel, user, unlocked)
+		}
+	}
+}
+
+// checkRoundAchievements checks the achievements of the users who answered the question, and of its author.
+func checkRoundAchievements(question *Question) {
+	entries, err := db.GetAnswerEntriesByQuestionID(question.ID)
+	if err != nil {
+		log.WithField("err", err).Error("Can't get answer entries")
+		return
+	}
+	userIDs := make([]uint, 0, len(entries)+1)
+	for _, entry := range entries {
+		userIDs = append(userIDs, entry.UserID)
+	}
+	if question.UserID != 0 {
+		userIDs = append(userIDs, question.UserID)
+	}
+	checkAchievements(TriggerRound, userIDs...)
+}
+
+// unlockAchievements unlocks the achievements of the trigger reached by the user,
+// and returns the ones it didn't have yet.
+func unlockAchievements(tx Store, trigger string, user *User) ([]UnlockedAchievement, error) {
+	unlocked, err := tx.GetUserAchievements(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	has := make(map[uint]bool, len(unlocked))
+	for _, userAchievement := range unlocked {
+		has[userAchievement.AchievementID] = true
+	}
+	var achievements []UnlockedAchievement
+	for _, rule := range achievementRules {
+		if rule.On != trigger {
+			continue
+		}
+		achievement, err := getAchievement(tx, &rule)
+		if err != nil {
+			return nil, err
+		}
+		if has[achievement.ID] {
+			continue
+		}
+		stat, err := achievementStats[rule.Stat](tx, user)
+		if err != nil {
+			return nil, err
+		}
+		if stat < rule.Threshold {
+			continue
+		}
+		userAchievement := &UserAchievement{UserID: user.ID, AchievementID: achievement.ID}
+		if err := tx.CreateUserAchievement(userAchievement); err == errAchievementUnlocked {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		achievements = append(achievements, UnlockedAchievement{Achievement: achievement, UnlockedAt: userAchievement.CreatedAt})
+	}
+	return achievements, nil
+}
+
+// getAchievement returns the achievement of the rule, created or updated to match it.
+func getAchievement(tx Store, rule *AchievementRule) (*Achievement, error) {
+	achievement, err := tx.GetAchievementByCode(rule.Code)
+	if err == gorm.ErrRecordNotFound {
+		achievement = &Achievement{Code: rule.Code, Name: rule.Name, Description: rule.Description}
+		return achievement, tx.CreateAchievement(achievement)
+	} else if err != nil {
+		return nil, err
+	}
+	if achievement.Name != rule.Name || achievement.Description != rule.Description {
+		achievement.Name, achievement.Description = rule.Name, rule.Description
+		return achievement, tx.SaveAchievement(achievement)
+	}
+	return achievement, nil
+}
+
+// announceAchievements posts the achievements unlocked by the user in the channel.
+func announceAchievements(channel string, user *User, achievements []UnlockedAchievement) {
+	name := fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+	if user.SlackID != "" {
+		name = fmt.Sprintf("<@%s>", user.SlackID)
+	}
+	for _, achievement := range achievements {
+		err := slackClient.PostMessage(&SlackMessage{
+			Channel: channel,
+			Text:    fmt.Sprintf(":trophy: %s unlocked *%s*: %s", name, achievement.Achievement.Name, achievement.Achievement.Description),
+		})
+		if err != nil {
+			log.WithField("err", err).Error("Can't announce achievement")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// adminAPIToken protects the admin endpoints. They're disabled when it's empty.
+var adminAPIToken = os.Getenv("ADMIN_API_TOKEN")
+
+// ScheduleQuestionRequest contains the data of schedule question request.
+// At is in the RFC 3339 format.
+type ScheduleQuestionRequest struct {
+	At string `schema:"at"`
+}
+
+// ScheduleQuestionResponse contains the scheduled question and its time.
+type ScheduleQuestionResponse struct {
+	Question    *Question
+	ScheduledAt time.Time
+}
+
+// verifyAdminToken is a middleware checking the bearer token of the request.
+func verifyAdminToken(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if adminAPIToken == "" || !hmac.Equal([]byte(token), []byte(adminAPIToken)) {
+		renderJSON(w, http.StatusUnauthorized, errInvalidToken)
+	}
+}
+
+// rotateQuestionNow starts a random question.
+func rotateQuestionNow(w http.ResponseWriter, r *http.Request) {
+	question, err := scheduler.RotateNow()
+	renderRotation(w, question, err)
+}
+
+// skipQuestion starts a random question without scoring the current one.
+func skipQuestion(w http.ResponseWriter, r *http.Request) {
+	question, err := scheduler.Skip()
+	renderRotation(w, question, err)
+}
+
+// scheduleQuestion schedules a question.
+func scheduleQuestion(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	id, err := strconv.ParseUint(params["question_id"], 10, 64)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, errInvalidQuestionID)
+		return
+	}
+	var req ScheduleQuestionRequest
+	if err := decodeRequestForm(r, &req); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	at, err := time.Parse(time.RFC3339, req.At)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{"Invalid at: " + err.Error()})
+		return
+	}
+	question, err := scheduler.Schedule(uint(id), at)
+	if err != nil {
+		renderJSON(w, questionErrorStatus(err), Error{err.Error()})
+		return
+	}
+	renderJSON(w, http.StatusOK, &ScheduleQuestionResponse{Question: question, ScheduledAt: question.ScheduledAt})
+}
+
+// renderRotation renders the next question, which starts once the results of the
+// previous one are revealed, or the rotation error.
+func renderRotation(w http.ResponseWriter, question *Question, err error) {
+	if err != nil {
+		renderJSON(w, questionErrorStatus(err), Error{err.Error()})
+		return
+	}
+	resp, err := newCurrentQuestionAnswer(db, question)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	renderJSON(w, http.StatusOK, resp)
+}
+
+// questionErrorStatus returns the HTTP status of an error of the questions.
+func questionErrorStatus(err error) int {
+	switch err {
+	case errQuestionNotFound:
+		return http.StatusNotFound
+	case errNoQuestionAvailable, errQuestionNotStartable, errRevealInProgress:
+		return http.StatusConflict
+	case errScheduleInPast:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
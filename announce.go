@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// slackAnnounceChannel is the channel where the question rotations are announced.
+var slackAnnounceChannel = os.Getenv("SLACK_ANNOUNCE_CHANNEL")
+
+// announceRotation posts the results of the previous question and
+// the next question in the announce channel, if any.
+func announceRotation(previous, next *Question) error {
+	if slackAnnounceChannel == "" {
+		return nil
+	}
+	msg, err := getRotationMessage(previous, next)
+	if err != nil {
+		return err
+	}
+	msg.Channel = slackAnnounceChannel
+	return slackClient.PostMessage(msg)
+}
+
+// getRotationMessage returns the message announcing the rotation.
+// previous is nil for the first question.
+func getRotationMessage(previous, next *Question) (*SlackMessage, error) {
+	msg := &SlackMessage{}
+	text := &bytes.Buffer{}
+	if previous != nil {
+		results, err := getResultsText(previous)
+		if err != nil {
+			return nil, err
+		}
+		text.WriteString(results)
+		text.WriteString("\n\n")
+		msg.Blocks = append(msg.Blocks,
+			SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: results}},
+			SlackBlock{Type: "divider"},
+		)
+	}
+	questionMsg, err := getQuestionMessage(next)
+	if err != nil {
+		return nil, err
+	}
+	answers, err := GetAnswersByQuestionID(next.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Can't get answers: %v", err)
+	}
+	fmt.Fprintf(text, "Next %s\n%s", questionMsg.Text, formatAnswers(answers))
+	msg.Text = text.String()
+	msg.Blocks = append(msg.Blocks, questionMsg.Blocks...)
+	return msg, nil
+}
+
+// getResultsText returns the right answer of the question and how many people got it right.
+func getResultsText(question *Question) (string, error) {
+	results, err := GetQuestionResults(db, question)
+	if err != nil {
+		return "", fmt.Errorf("Can't get results: %v", err)
+	}
+	rightAnswer := results.RightAnswer
+	if rightAnswer == "" {
+		rightAnswer = "unknown"
+	}
+	return fmt.Sprintf("Time's up! The answer to %q was *%s*.\n%d of %d people got it right.",
+		question.Sentence, rightAnswer, len(results.Winners), results.Total), nil
+}
+
+// formatAnswers returns the numbered answers, e.g. "1. Yes, 2. No".
+func formatAnswers(answers []Answer) string {
+	buff := &bytes.Buffer{}
+	lastAnswerIndex := len(answers) - 1
+	for i, answer := range answers {
+		fmt.Fprintf(buff, "%d. %s", i+1, answer.Sentence)
+		if i < lastAnswerIndex {
+			buff.WriteString(", ")
+		}
+	}
+	return buff.String()
+}
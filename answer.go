@@ -12,7 +12,6 @@ type Answer struct {
 }
 
 // GetAnswersByQuestionID returns the answers associated to the questionID.
-func GetAnswersByQuestionID(questionID uint) (answers []Answer, err error) {
-	err = db.Where(&Answer{QuestionID: questionID}).Find(&answers).Error
-	return
+func GetAnswersByQuestionID(questionID uint) ([]Answer, error) {
+	return db.GetAnswersByQuestionID(questionID)
 }
@@ -1,31 +1,48 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-martini/martini"
+	"github.com/gorilla/websocket"
+	"github.com/jinzhu/gorm"
 )
 
 const (
 	ContentFormURLEncoded = "application/x-www-form-urlencoded"
 )
 
-var mc *martini.Martini
+var (
+	mc        *martini.Martini
+	fakeSlack = &fakeSlackClient{}
+)
 
 func TestMain(m *testing.M) {
 	rand.Seed(time.Now().Unix())
+	if os.Getenv("DB_DRIVER") == "" {
+		os.Setenv("DB_DRIVER", "memory")
+	}
+	if err := loadConfig(); err != nil {
+		log.Fatal("Invalid config:", err)
+	}
 	InitDB()
-	db.LogMode(true)
-	initSlackServer()
+	initSlackClient()
 	teardown()
 	mc = NewWebService()
 	os.Exit(m.Run())
@@ -35,7 +52,7 @@ func TestGetLastImage(t *testing.T) {
 	defer teardown()
 	imageURL := "http://localhost.com/image.png"
 	userID := uint(1)
-	db.Create(&Image{URL: imageURL, UserID: userID})
+	db.CreateImage(&Image{URL: imageURL, UserID: userID})
 	resp := DoRequest(newRequest(t, "GET", "/images/latest", nil))
 	if resp.Code != http.StatusOK {
 		t.Fatal("Invalid status code:", resp.Code)
@@ -52,7 +69,7 @@ func TestGetLastImage(t *testing.T) {
 func TestUsers(t *testing.T) {
 	defer teardown()
 	user := &User{FirstName: "John", LastName: "Doe"}
-	db.Create(user)
+	db.CreateUser(user)
 	resp := DoRequest(newRequest(t, "GET", "/users/1", nil))
 	if resp.Code != http.StatusOK {
 		t.Fatal("Invalid status code:", resp.Code)
@@ -69,22 +86,263 @@ func TestUsers(t *testing.T) {
 func TestAddMessage(t *testing.T) {
 	defer teardown()
 	addTestMessage(t, "UD10923", "helloworld")
-	u := &User{}
-	if err := db.First(u, 1).Error; err != nil {
+	u, err := db.GetUser(1)
+	if err != nil {
 		t.Fatal("Can't get user:", err)
 	}
 	if u.ID != 1 || u.SlackID != "UD10923" || u.FirstName != "John" || u.LastName != "Doe" || u.ImageURL != "http://localhost/image.jpg" {
 		t.Fatal("Invalid user:", u)
 	}
-	message := &Message{}
-	if err := db.First(message, 1).Error; err != nil {
+	messages, err := db.GetMessages(0, 1)
+	if err != nil || len(messages) != 1 {
 		t.Fatal("Can't get message:", err)
 	}
-	if message.ID != 1 || message.Message != "helloworld" {
+	if message := messages[0]; message.ID != 1 || message.Message != "helloworld" {
 		t.Fatal("Invalid message:", message)
 	}
 }
 
+func TestUserCache(t *testing.T) {
+	defer teardown()
+	addTestMessage(t, "UD10923", "hello")
+	addTestMessage(t, "UD10923", "world")
+	if calls := fakeSlack.UserInfoCalls(); calls != 1 {
+		t.Fatal("Wrong users.info calls:", calls)
+	}
+	event := `{"type":"user_change","user":{"id":"UD10923","profile":{"first_name":"Jane","last_name":"Doe","image_192":"http://localhost/jane.jpg"}}}`
+	postTestEvent(t, fmt.Sprintf(`{"token":%q,"type":"event_callback","event_id":"EvUser","event":%s}`, slackCommandToken, event))
+	user, err := GetUserBySlackID("UD10923")
+	if err != nil {
+		t.Fatal("Can't get user:", err)
+	}
+	if user.ID != 1 || user.FirstName != "Jane" || user.ImageURL != "http://localhost/jane.jpg" {
+		t.Fatal("Invalid user:", user)
+	}
+	if calls := fakeSlack.UserInfoCalls(); calls != 1 {
+		t.Fatal("Wrong users.info calls:", calls)
+	}
+
+	// The users are read from the store, not from the cache.
+	if err := db.SetUserRole(user.ID, RoleModerator); err != nil {
+		t.Fatal("Can't set role:", err)
+	}
+	if user, err := GetUserBySlackID("UD10923"); err != nil || user.Role != RoleModerator {
+		t.Fatal("Stale user:", user, err)
+	}
+
+	// A stale profile is refreshed in the background.
+	userCache.ttl = 0
+	defer func() { userCache.ttl = time.Hour }()
+	if _, err := GetUserBySlackID("UD10923"); err != nil {
+		t.Fatal("Can't get user:", err)
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		userCache.mu.Lock()
+		refreshing := len(userCache.refreshing)
+		userCache.mu.Unlock()
+		if refreshing == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Profile not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls := fakeSlack.UserInfoCalls(); calls != 2 {
+		t.Fatal("Wrong users.info calls:", calls)
+	}
+
+	cache := newUserProfileCache(time.Hour, 1)
+	cache.Put(&userProfile{SlackID: "UD1"})
+	cache.Put(&userProfile{SlackID: "UD2"})
+	if _, ok := cache.get("UD1"); ok {
+		t.Fatal("Least recently used profile not evicted")
+	}
+}
+
+func TestSlackEvents(t *testing.T) {
+	defer teardown()
+	resp := postTestEvent(t, fmt.Sprintf(`{"token":%q,"type":"url_verification","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`, slackCommandToken))
+	var challenge map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+		t.Fatal("Can't decode challenge:", err)
+	}
+	if challenge["challenge"] != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Fatal("Invalid challenge:", challenge)
+	}
+	events := []string{
+		`{"type":"message","channel":"C2147483705","user":"UD10923","text":"hello","ts":"1355517523.000005"}`,
+		`{"type":"message","channel":"C2147483705","user":"UD10923","text":"world","ts":"1355517524.000005"}`,
+		`{"type":"message","subtype":"bot_message","channel":"C2147483705","bot_id":"B123","text":"beep","ts":"1355517525.000005"}`,
+		`{"type":"message","subtype":"message_changed","channel":"C2147483705","message":{"user":"UD10923","text":"hello world","ts":"1355517523.000005"}}`,
+		`{"type":"message","subtype":"message_deleted","channel":"C2147483705","deleted_ts":"1355517524.000005"}`,
+	}
+	for i, event := range events {
+		body := fmt.Sprintf(`{"token":%q,"type":"event_callback","event_id":"Ev%d","event":%s}`, slackCommandToken, i, event)
+		postTestEvent(t, body)
+		// Slack retries the events it didn't get an answer for in time.
+		postTestEvent(t, body)
+	}
+	messages, err := db.GetMessages(0, 10)
+	if err != nil {
+		t.Fatal("Can't get messages:", err)
+	}
+	if len(messages) != 1 {
+		t.Fatal("Wrong messages number:", len(messages))
+	}
+	if messages[0].Message != "hello world" || messages[0].SentAt.Unix() != 1355517523 {
+		t.Fatal("Invalid message:", messages[0])
+	}
+
+	// The events without id are all handled.
+	for _, ts := range []string{"1355517526.000005", "1355517527.000005"} {
+		event := fmt.Sprintf(`{"type":"message","channel":"C2147483705","user":"UD10923","text":"again","ts":%q}`, ts)
+		postTestEvent(t, fmt.Sprintf(`{"token":%q,"type":"event_callback","event":%s}`, slackCommandToken, event))
+	}
+	if messages, err = db.GetMessages(0, 10); err != nil || len(messages) != 3 {
+		t.Fatal("Wrong messages:", messages, err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	defer teardown()
+	server := httptest.NewServer(mc)
+	defer server.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+	subscribe := func(lastEventID string) (*http.Response, *bufio.Reader) {
+		req := newRequest(t, "GET", server.URL+"/events", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal("Can't get events:", err)
+		}
+		if resp.Header.Get(ContentType) != "text/event-stream" || resp.Header.Get("Content-Encoding") != "" {
+			t.Fatal("Invalid headers:", resp.Header)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	resp, stream := subscribe("")
+	addTestMessage(t, "UD10923", "hello")
+	id, eventType, data := readTestEvent(t, stream)
+	resp.Body.Close()
+	if eventType != EventMessageCreated || !strings.Contains(data, `"Message":"hello"`) {
+		t.Fatal("Invalid event:", eventType, data)
+	}
+
+	addTestMessage(t, "UD10923", "world")
+	resp, stream = subscribe(id)
+	_, eventType, data = readTestEvent(t, stream)
+	resp.Body.Close()
+	if eventType != EventMessageCreated || !strings.Contains(data, `"Message":"world"`) {
+		t.Fatal("Invalid resumed event:", eventType, data)
+	}
+
+	resp, stream = subscribe("1")
+	_, eventType, _ = readTestEvent(t, stream)
+	resp.Body.Close()
+	if eventType != EventReset {
+		t.Fatal("Missed events not reported:", eventType)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	defer teardown()
+	server := httptest.NewServer(mc)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?screen=lobby", nil)
+	if err != nil {
+		t.Fatal("Can't connect:", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.WriteJSON(ScreenRequest{Type: "subscribe", Topics: []string{TopicMessages}}); err != nil {
+		t.Fatal("Can't subscribe:", err)
+	}
+	var msg struct {
+		Topic string
+		Event string
+		Data  json.RawMessage
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal("Can't read state:", err)
+	}
+	if msg.Topic != TopicMessages || msg.Event != "" {
+		t.Fatal("Invalid state:", msg.Topic, msg.Event)
+	}
+
+	resp := DoRequest(newRequest(t, "GET", "/screens", nil))
+	var list []Screen
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal("Can't decode screens:", err)
+	}
+	if len(list) != 1 || list[0].Name != "lobby" || len(list[0].Topics) != 1 || list[0].Topics[0] != TopicMessages {
+		t.Fatal("Invalid screens:", list)
+	}
+
+	// Images aren't subscribed to.
+	events.Publish(EventImageCreated, &Image{URL: "http://localhost/image.png"})
+	addTestMessage(t, "UD10923", "hello")
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal("Can't read event:", err)
+	}
+	var message Message
+	if err := json.Unmarshal(msg.Data, &message); err != nil {
+		t.Fatal("Can't decode message:", err)
+	}
+	if msg.Topic != TopicMessages || msg.Event != EventMessageCreated || message.Message != "hello" {
+		t.Fatal("Invalid event:", msg.Topic, msg.Event, string(msg.Data))
+	}
+}
+
+func TestDisplayState(t *testing.T) {
+	defer teardown()
+	var state struct {
+		Version     uint64
+		Images      *DisplaySection
+		Messages    *DisplaySection
+		Question    *DisplaySection
+		Leaderboard *DisplaySection
+	}
+	resp := DoRequest(newRequest(t, "GET", "/display/state", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatal("Can't get display state:", resp.Code)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal("Can't decode display state:", err)
+	}
+	if state.Images == nil || state.Messages == nil || state.Question == nil || state.Leaderboard == nil {
+		t.Fatal("Missing sections:", state)
+	}
+
+	addTestMessage(t, "UD10923", "hello")
+	since := state.Version
+	state.Images, state.Messages, state.Question, state.Leaderboard = nil, nil, nil, nil
+	resp = DoRequest(newRequest(t, "GET", fmt.Sprintf("/display/state?since=%d", since), nil))
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal("Can't decode display state:", err)
+	}
+	if state.Messages == nil || state.Images != nil || state.Question != nil || state.Leaderboard != nil {
+		t.Fatal("Invalid changed sections:", state)
+	}
+	if state.Version <= since || state.Messages.Version != state.Version {
+		t.Fatal("Invalid versions:", state.Version, since)
+	}
+	messages, ok := state.Messages.Data.([]interface{})
+	if !ok || len(messages) != 1 {
+		t.Fatal("Invalid messages:", state.Messages.Data)
+	}
+
+	req := newRequest(t, "GET", "/display/state", nil)
+	req.Header.Set("If-None-Match", resp.Header().Get("ETag"))
+	if resp := DoRequest(req); resp.Code != http.StatusNotModified {
+		t.Fatal("Unchanged state returned:", resp.Code)
+	}
+}
+
 func TestGetMessages(t *testing.T) {
 	defer teardown()
 	for i := 0; i < 10; i++ {
@@ -122,7 +380,7 @@ func TestGetUsersTop(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		newID := fmt.Sprintf("UD%d", i)
 		user := &User{SlackID: newID, FirstName: "John", LastName: "Doe", Points: uint(i)}
-		if err := db.Create(user).Error; err != nil {
+		if err := db.CreateUser(user); err != nil {
 			t.Fatal("Can't update user:", err.Error())
 		}
 	}
@@ -141,9 +399,118 @@ func TestGetUsersTop(t *testing.T) {
 	}
 }
 
+func TestLeaderboardPeriods(t *testing.T) {
+	defer teardown()
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe"})
+	db.CreateUser(&User{SlackID: "UD10924", FirstName: "Jane", LastName: "Roe"})
+	db.CreateUser(&User{SlackID: "UD10925", FirstName: "Jim", LastName: "Poe", Points: 50})
+	db.CreateQuestion(&Question{UserID: 3, Sentence: "Help?", RightAnswerID: 1, Status: QuestionApproved})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 2})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 1})
+	db.AddPointEvent(&PointEvent{UserID: 1, QuestionID: 1, Points: 10, Reason: ReasonRightAnswer})
+	db.AddPointEvent(&PointEvent{UserID: 2, QuestionID: 1, Points: 10, Reason: ReasonRightAnswer})
+
+	for period, want := range map[string][]uint{"week": {2, 1}, "all": {3, 2, 1}} {
+		resp := DoRequest(newRequest(t, "GET", "/users/top?period="+period, nil))
+		var standings []Standing
+		if err := json.Unmarshal(resp.Body.Bytes(), &standings); err != nil {
+			t.Fatal("Can't decode leaderboard:", resp.Code, resp.Body.String())
+		}
+		if len(standings) != len(want) {
+			t.Fatal("Invalid leaderboard:", period, resp.Body.String())
+		}
+		for i, id := range want {
+			if standings[i].ID != id || standings[i].Rank != i+1 {
+				t.Fatal("Invalid leaderboard:", period, resp.Body.String())
+			}
+		}
+	}
+	if resp := DoRequest(newRequest(t, "GET", "/users/top?period=year", nil)); resp.Code != http.StatusBadRequest {
+		t.Fatal("Invalid period accepted:", resp.Code)
+	}
+	if standings, err := GetLeaderboard(db, time.Now().Add(time.Second), time.Time{}, 0); err != nil || len(standings) != 0 {
+		t.Fatal("Points before the start in the leaderboard:", standings, err)
+	}
+
+	paris, _ := time.LoadLocation("Europe/Paris")
+	defer func(location *time.Location) { questionLocation = location }(questionLocation)
+	questionLocation = paris
+	now := time.Date(2017, 3, 5, 23, 30, 0, 0, time.UTC)
+	for period, want := range map[string]time.Time{
+		PeriodDay:   time.Date(2017, 3, 6, 0, 0, 0, 0, paris),
+		PeriodWeek:  time.Date(2017, 3, 6, 0, 0, 0, 0, paris),
+		PeriodMonth: time.Date(2017, 3, 1, 0, 0, 0, 0, paris),
+	} {
+		if start, err := periodStart(period, now); err != nil || !start.Equal(want) {
+			t.Fatal("Invalid period start:", period, start, err)
+		}
+	}
+}
+
+func TestSeasons(t *testing.T) {
+	defer teardown()
+	for i, points := range []int{5, 20, 10, 15} {
+		db.CreateUser(&User{SlackID: fmt.Sprintf("UD%d", i), FirstName: "John", LastName: "Doe", Streak: 2})
+		db.AddPointEvent(&PointEvent{UserID: uint(i + 1), Points: points, Reason: ReasonAdjustment})
+	}
+	db.CreateUser(&User{SlackID: "UD10925", FirstName: "Jim", LastName: "Poe", Points: 50})
+	if _, err := rolloverSeason(db, "first", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal("Can't start season:", err)
+	}
+	var standings []Standing
+	resp := DoRequest(newRequest(t, "GET", "/users/top", nil))
+	if err := json.Unmarshal(resp.Body.Bytes(), &standings); err != nil || len(standings) != 4 || standings[0].ID != 2 {
+		t.Fatal("Invalid leaderboard of the season:", resp.Code, resp.Body.String())
+	}
+
+	if _, err := rolloverSeason(db, "second", time.Now().Add(time.Second)); err != nil {
+		t.Fatal("Can't roll season over:", err)
+	}
+	if user, err := GetUser(1); err != nil || user.Streak != 0 || user.Points != 5 {
+		t.Fatal("Invalid user after the season:", user, err)
+	}
+	if standings, err := GetSeasonLeaderboard(db, leaderboardSize); err != nil || len(standings) != 0 {
+		t.Fatal("Points of the previous season on the TVs:", standings, err)
+	}
+	var seasons []SeasonDetail
+	resp = DoRequest(newRequest(t, "GET", "/seasons", nil))
+	if err := json.Unmarshal(resp.Body.Bytes(), &seasons); err != nil || len(seasons) != 1 {
+		t.Fatal("Invalid seasons:", resp.Code, resp.Body.String())
+	}
+	if s := seasons[0]; s.Season.Name != "first" || s.Season.EndedAt.IsZero() || len(s.Standings) != podiumSize {
+		t.Fatal("Invalid past season:", resp.Body.String())
+	}
+	for i, want := range []uint{2, 4, 3} {
+		if s := seasons[0].Standings[i]; s.ID != want || s.Rank != i+1 || s.FirstName != "John" {
+			t.Fatal("Invalid podium:", resp.Body.String())
+		}
+	}
+	var detail SeasonDetail
+	resp = DoRequest(newRequest(t, "GET", "/seasons/1", nil))
+	if err := json.Unmarshal(resp.Body.Bytes(), &detail); err != nil || len(detail.Standings) != 4 || detail.Standings[3].Points != 5 {
+		t.Fatal("Invalid season standings:", resp.Code, resp.Body.String())
+	}
+	resp = DoRequest(newRequest(t, "GET", "/seasons/current", nil))
+	if err := json.Unmarshal(resp.Body.Bytes(), &detail); err != nil || detail.Season.Name != "second" || len(detail.Standings) != 0 {
+		t.Fatal("Invalid current season:", resp.Code, resp.Body.String())
+	}
+
+	defer func() { seasonPeriod = "" }()
+	seasonPeriod = PeriodMonth
+	now := time.Now().AddDate(0, 1, 0)
+	checkSeason(now)
+	checkSeason(now)
+	if season, err := db.GetCurrentSeason(); err != nil || season.Name != now.In(questionLocation).Format("2006-01") {
+		t.Fatal("Season not rolled over:", season, err)
+	}
+	if seasons, err := db.GetPastSeasons(); err != nil || len(seasons) != 2 {
+		t.Fatal("Season rolled over twice:", seasons, err)
+	}
+}
+
 func TestGetCurrentQuestion(t *testing.T) {
 	defer teardown()
-	db.Create(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, Status: QuestionApproved})
 	req := newRequest(t, "GET", "/questions/current", nil)
 	req.Header.Set(ContentType, ContentFormURLEncoded)
 	resp := DoRequest(req)
@@ -154,10 +521,10 @@ func TestGetCurrentQuestion(t *testing.T) {
 
 func TestNextQuestion(t *testing.T) {
 	defer teardown()
-	db.Create(&User{FirstName: "John", LastName: "Doe", Points: 0})
-	db.Create(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, StartedAt: time.Now()})
-	db.Create(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 1})
-	db.Create(&Question{UserID: 1, Sentence: "Donation?", RightAnswerID: 2})
+	db.CreateUser(&User{FirstName: "John", LastName: "Doe", Points: 0})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, StartedAt: time.Now(), Status: QuestionApproved})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 1})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Donation?", RightAnswerID: 2, Status: QuestionApproved})
 	if err := nextQuestion(); err != nil {
 		t.Fatal("Can't execute next question:", err)
 	}
@@ -170,6 +537,611 @@ func TestNextQuestion(t *testing.T) {
 	}
 }
 
+func TestQuestionResults(t *testing.T) {
+	defer teardown()
+	defer func() { revealDuration = 0 }()
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe"})
+	db.CreateUser(&User{SlackID: "UD10924", FirstName: "Jane", LastName: "Roe"})
+	db.CreateUser(&User{SlackID: "UD10925", FirstName: "Jim", LastName: "Poe"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, StartedAt: time.Now(), Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "Yes"})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "No"})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 1})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 2, UserID: 2})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 3})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Donation?", RightAnswerID: 3, Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 2, Sentence: "Sure"})
+
+	if resp := DoRequest(newRequest(t, "GET", "/questions/1/results", nil)); resp.Code != http.StatusConflict {
+		t.Fatal("Results of a running question:", resp.Code, resp.Body.String())
+	}
+	if resp := DoRequest(newRequest(t, "GET", "/questions/42/results", nil)); resp.Code != http.StatusNotFound {
+		t.Fatal("Results of a missing question:", resp.Code)
+	}
+
+	// Long enough to never start the next question during the tests.
+	revealDuration = time.Hour
+	if err := nextQuestion(); err != nil {
+		t.Fatal("Can't execute next question:", err)
+	}
+	if err := nextQuestion(); err != errRevealInProgress {
+		t.Fatal("Rotated during the reveal:", err)
+	}
+	user, _ := GetUser(1)
+	if resp := slackCommandTVAnswer(&SlackCommandRequest{Text: "answer 2"}, user); resp.Text != errQuestionOver.Error() {
+		t.Fatal("Answered during the reveal:", resp.Text)
+	}
+
+	resp := DoRequest(newRequest(t, "GET", "/questions/current", nil))
+	var current GetCurrentQuestionAnswer
+	if err := json.Unmarshal(resp.Body.Bytes(), &current); err != nil {
+		t.Fatal("Can't decode current question:", err)
+	}
+	if current.Question.ID != 1 || current.Reveal == nil || current.Reveal.Results.RightAnswer != "Yes" {
+		t.Fatal("Invalid reveal:", resp.Body.String())
+	}
+
+	resp = DoRequest(newRequest(t, "GET", "/questions/1/results", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatal("Can't get results:", resp.Code, resp.Body.String())
+	}
+	var results QuestionResults
+	if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil {
+		t.Fatal("Can't decode results:", err)
+	}
+	if results.RightAnswerID != 1 || results.Total != 3 || len(results.Answers) != 2 {
+		t.Fatal("Invalid results:", resp.Body.String())
+	}
+	if a := results.Answers[0]; a.Count != 2 || a.Percent != 66.7 || !a.Right {
+		t.Fatal("Invalid right answer result:", a)
+	}
+	if a := results.Answers[1]; a.Count != 1 || a.Percent != 33.3 || a.Right {
+		t.Fatal("Invalid wrong answer result:", a)
+	}
+	if len(results.Winners) != 2 || results.Winners[0].FirstName != "John" || results.Winners[1].FirstName != "Jim" {
+		t.Fatal("Invalid winners:", results.Winners)
+	}
+}
+
+func TestGetQuestions(t *testing.T) {
+	defer teardown()
+	now := time.Now()
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, StartedAt: now.Add(-2 * time.Hour), EndedAt: now.Add(-time.Hour), Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "Yes"})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 1})
+	db.CreateQuestion(&Question{UserID: 2, Sentence: "Donation?", RightAnswerID: 2, StartedAt: now.Add(-time.Hour), Status: QuestionApproved})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Coffee?", RightAnswerID: 3, Status: QuestionApproved})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Tea?", Status: QuestionPending})
+
+	tests := []struct {
+		query string
+		ids   []uint
+		total int
+	}{
+		{"", []uint{2, 1, 3}, 3},
+		{"?status=past", []uint{1}, 1},
+		{"?status=current", []uint{2}, 1},
+		{"?status=upcoming", []uint{3}, 1},
+		{"?user_id=1", []uint{1, 3}, 2},
+		{"?page=2&count=1", []uint{1}, 3},
+		{"?from=" + url.QueryEscape(now.Add(-90*time.Minute).Format(time.RFC3339)), []uint{2}, 1},
+	}
+	for _, test := range tests {
+		resp := DoRequest(newRequest(t, "GET", "/questions"+test.query, nil))
+		var page GetQuestionsResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &page); err != nil {
+			t.Fatal("Can't decode questions:", test.query, resp.Body.String())
+		}
+		var ids []uint
+		for _, detail := range page.Questions {
+			ids = append(ids, detail.Question.ID)
+			if (detail.Results != nil) != (detail.State == QuestionPast) {
+				t.Fatal("Results of a question not ended:", test.query, resp.Body.String())
+			}
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.ids) || page.Total != test.total {
+			t.Fatal("Invalid questions:", test.query, ids, page.Total)
+		}
+		if test.query == "?status=upcoming" && strings.Contains(resp.Body.String(), "RightAnswer") {
+			t.Fatal("Right answer of an upcoming question leaked:", resp.Body.String())
+		}
+	}
+	for _, query := range []string{"?status=asked", "?count=1000", "?from=yesterday"} {
+		if resp := DoRequest(newRequest(t, "GET", "/questions"+query, nil)); resp.Code != http.StatusBadRequest {
+			t.Fatal("Invalid query accepted:", query, resp.Code)
+		}
+	}
+
+	resp := DoRequest(newRequest(t, "GET", "/questions/1", nil))
+	var detail QuestionDetail
+	if err := json.Unmarshal(resp.Body.Bytes(), &detail); err != nil {
+		t.Fatal("Can't decode question:", resp.Body.String())
+	}
+	if detail.State != QuestionPast || detail.Results == nil || detail.Results.RightAnswer != "Yes" || len(detail.Answers) != 1 {
+		t.Fatal("Invalid past question:", resp.Body.String())
+	}
+	if resp := DoRequest(newRequest(t, "GET", "/questions/4", nil)); resp.Code != http.StatusNotFound {
+		t.Fatal("Pending question returned:", resp.Code)
+	}
+}
+
+func TestQuestionBank(t *testing.T) {
+	defer teardown()
+	defer func() { adminAPIToken = "" }()
+	adminAPIToken = "legitAdminToken42"
+	bank := "sentence,answer_1,answer_2,answer_3,right_answer,category,author\n" +
+		"Help?,Yes,No,,1,misc,UD10923\n" +
+		"Donation?,Sure,Never ever ever ever ever ever ever,,2,,\n" +
+		"Coffee?,Yes,No,Maybe,4,drinks,\n" +
+		"Tea?,Yes\n"
+	for i, want := range []ImportReport{{Created: 1}, {Skipped: 1}} {
+		req := newRequest(t, "POST", "/admin/questions/import?format=csv", strings.NewReader(bank))
+		req.Header.Set("Authorization", "Bearer legitAdminToken42")
+		resp := DoRequest(req)
+		var report ImportReport
+		if err := json.Unmarshal(resp.Body.Bytes(), &report); err != nil {
+			t.Fatal("Can't decode import report:", resp.Code, resp.Body.String())
+		}
+		if report.Created != want.Created || report.Skipped != want.Skipped || len(report.Errors) != 3 {
+			t.Fatal("Invalid import report:", i, resp.Body.String())
+		}
+		if report.Errors[0].Row != 2 || report.Errors[0].Error != "Answer 2 is too long maximum 32 characters" || report.Errors[1].Row != 3 || report.Errors[2].Row != 4 {
+			t.Fatal("Invalid import errors:", report.Errors)
+		}
+	}
+	if q, err := db.GetQuestion(1); err != nil || q.Status != QuestionApproved || q.Category != "misc" || q.UserID != 1 {
+		t.Fatal("Invalid imported question:", q, err)
+	}
+
+	req := newRequest(t, "GET", "/admin/questions/export?format=yaml", nil)
+	req.Header.Set("Authorization", "Bearer legitAdminToken42")
+	resp := DoRequest(req)
+	entries, err := decodeQuestionBank(resp.Body, FormatYAML)
+	if err != nil {
+		t.Fatal("Can't decode exported questions:", err)
+	}
+	want := QuestionBankEntry{Sentence: "Help?", Answers: []string{"Yes", "No"}, RightAnswer: 1, Category: "misc", Author: "UD10923"}
+	if len(entries) != 1 || fmt.Sprint(entries[0]) != fmt.Sprint(want) {
+		t.Fatal("Invalid exported questions:", entries)
+	}
+
+	path := os.TempDir() + "/questions_test.json"
+	defer os.Remove(path)
+	out := &bytes.Buffer{}
+	if err := exportCommand(out, db, path, FormatJSON); err != nil {
+		t.Fatal("Can't export questions:", err)
+	}
+	if err := importCommand(out, db, path, FormatJSON); err != nil || !strings.HasSuffix(out.String(), "0 created, 1 skipped, 0 failed\n") {
+		t.Fatal("Can't import exported questions:", err, out.String())
+	}
+
+	user, _ := GetUser(1)
+	cmd := &SlackCommandRequest{Text: "question Alive? 1 true falsefalsefalsefalsefalsefalsefalse"}
+	if resp := slackCommandTVQuestion(cmd, user); resp.Text != "Error: Answer 2 is too long maximum 32 characters" {
+		t.Fatal("Invalid answer length error:", resp.Text)
+	}
+}
+
+func TestQuestionCategories(t *testing.T) {
+	defer teardown()
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe"})
+	db.CreateUser(&User{SlackID: "UD10924", FirstName: "Jane", LastName: "Roe"})
+	user, _ := GetUser(1)
+	cmd := &SlackCommandRequest{Text: "question --category Geography --tags=maps,Europe --tags maps Capital? 1 Paris Lyon"}
+	if resp := slackCommandTVQuestion(cmd, user); resp.Text != "Your question has been submitted. Thank You!" {
+		t.Fatal("Can't submit question with category:", resp.Text)
+	}
+	if q, err := db.GetQuestion(1); err != nil || q.Sentence != "Capital?" || q.Category != "geography" || q.Tags != "europe,maps" {
+		t.Fatal("Invalid question category:", q, err)
+	}
+	cmd = &SlackCommandRequest{Text: "question --theme geography Capital? 1 Paris Lyon"}
+	if resp := slackCommandTVQuestion(cmd, user); !strings.HasPrefix(resp.Text, "Error: Unknown flag --theme") {
+		t.Fatal("Unknown flag accepted:", resp.Text)
+	}
+
+	questions := []Question{{Category: "art"}, {Category: "geography"}, {Category: "history"}}
+	roundRobin, _ := newRotationPolicy(PolicyRoundRobin, "", time.UTC)
+	for last, want := range map[string]string{"art": "geography", "geography": "history", "history": "art", "": "art"} {
+		if q := roundRobin.Pick(questions, last, time.Now()); q.Category != want {
+			t.Fatal("Invalid round-robin category:", last, q.Category)
+		}
+	}
+	themed, err := newRotationPolicy(PolicyThemed, "fri:geography=1,art=0,history=0", time.UTC)
+	if err != nil {
+		t.Fatal("Can't parse themes:", err)
+	}
+	friday := time.Date(2017, 3, 3, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		if q := themed.Pick(questions, "", friday); q.Category != "geography" {
+			t.Fatal("Invalid themed category:", q.Category)
+		}
+	}
+	for _, themes := range []string{"funday:art=1", "fri:art", "fri:art=-1"} {
+		if _, err := newRotationPolicy(PolicyThemed, themes, time.Local); err == nil {
+			t.Fatal("Invalid themes accepted:", themes)
+		}
+	}
+	if _, err := newRotationPolicy("fifo", "", time.Local); err == nil {
+		t.Fatal("Invalid policy accepted")
+	}
+
+	ended := time.Now().Add(-time.Hour)
+	db.CreateQuestion(&Question{Sentence: "River?", Category: "geography", RightAnswerID: 1, StartedAt: ended, EndedAt: ended, Status: QuestionApproved})
+	db.CreateQuestion(&Question{Sentence: "War?", Category: "history", RightAnswerID: 2, StartedAt: ended, EndedAt: ended, Status: QuestionApproved})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 2, AnswerID: 1, UserID: 2})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 3, AnswerID: 2, UserID: 1})
+	resp := DoRequest(newRequest(t, "GET", "/users/top?category=Geography", nil))
+	var users []User
+	if err := json.Unmarshal(resp.Body.Bytes(), &users); err != nil {
+		t.Fatal("Can't decode users top:", resp.Body.String())
+	}
+	if len(users) != 1 || users[0].ID != 2 || users[0].Points != 1 {
+		t.Fatal("Invalid category users top:", resp.Body.String())
+	}
+}
+
+func TestScoring(t *testing.T) {
+	defer teardown()
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe", Streak: 2})
+	db.CreateUser(&User{SlackID: "UD10924", FirstName: "Jane", LastName: "Roe", Streak: 4})
+	db.CreateUser(&User{SlackID: "UD10925", FirstName: "Jim", LastName: "Poe"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, StartedAt: time.Now().Add(-4 * time.Minute), Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "Yes"})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "No"})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 1})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 2, UserID: 2})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 3})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Donation?", Status: QuestionApproved})
+	if err := nextQuestion(); err != nil {
+		t.Fatal("Can't execute next question:", err)
+	}
+	// 10 points, 3 for the speed, 5 for the difficulty as 1 of the 2 others
+	// got it wrong, and 2 for each previous right answer in a row.
+	for id, want := range map[uint][2]uint{1: {22, 3}, 2: {0, 0}, 3: {18, 1}} {
+		if user, err := GetUser(id); err != nil || user.Points != want[0] || user.Streak != want[1] {
+			t.Fatal("Invalid points or streak:", id, user, err)
+		}
+	}
+}
+
+func TestPointsHistory(t *testing.T) {
+	defer teardown()
+	defer func() { adminAPIToken = "" }()
+	adminAPIToken = "legitAdminToken42"
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe"})
+	db.CreateUser(&User{SlackID: "UD10924", FirstName: "Jane", LastName: "Roe", Points: 7})
+	db.AddPointEvent(&PointEvent{UserID: 1, QuestionID: 1, Points: 10, Reason: ReasonRightAnswer})
+	db.AddPointEvent(&PointEvent{UserID: 1, QuestionID: 1, Points: 3, Reason: ReasonSpeed})
+
+	req := newRequest(t, "POST", "/admin/users/1/points", strings.NewReader("points=-5&reason=double+answer"))
+	req.Header.Set(ContentType, "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer legitAdminToken42")
+	if resp := DoRequest(req); resp.Code != http.StatusOK {
+		t.Fatal("Can't adjust points:", resp.Code, resp.Body.String())
+	}
+	if _, err := adjustPoints(db, 1, -9, ""); err != errNegativePoints {
+		t.Fatal("Points adjusted below 0:", err)
+	}
+
+	resp := DoRequest(newRequest(t, "GET", "/users/1/points/history?count=2", nil))
+	var history PointsHistory
+	if err := json.Unmarshal(resp.Body.Bytes(), &history); err != nil {
+		t.Fatal("Can't decode points history:", resp.Code, resp.Body.String())
+	}
+	if history.Points != 8 || history.Total != 3 || len(history.Events) != 2 {
+		t.Fatal("Invalid points history:", resp.Body.String())
+	}
+	if e := history.Events[0]; e.Points != -5 || e.Reason != ReasonAdjustment || e.Note != "double answer" || history.Events[1].Reason != ReasonSpeed {
+		t.Fatal("Invalid point events:", history.Events)
+	}
+	if resp := DoRequest(newRequest(t, "GET", "/users/3/points/history", nil)); resp.Code != http.StatusNotFound {
+		t.Fatal("Points history of unknown user:", resp.Code)
+	}
+
+	corrections, err := recomputePoints(db)
+	if err != nil || len(corrections) != 1 || corrections[0].User.ID != 2 || corrections[0].Before != 7 || corrections[0].After != 0 {
+		t.Fatal("Invalid points corrections:", corrections, err)
+	}
+	if corrections, err := recomputePoints(db); err != nil || len(corrections) != 0 {
+		t.Fatal("Points corrected twice:", corrections, err)
+	}
+	if err := resetPoints(db); err != nil {
+		t.Fatal("Can't reset points:", err)
+	}
+	if user, err := GetUser(1); err != nil || user.Points != 0 {
+		t.Fatal("Points not reset:", user, err)
+	}
+	if events, total, err := db.GetPointEvents(1, 0, 1); err != nil || total != 4 || events[0].Points != -8 || events[0].Reason != ReasonReset {
+		t.Fatal("Reset not recorded:", events, total, err)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	defer teardown()
+	defer func() { adminAPIToken = "" }()
+	db.CreateUser(&User{FirstName: "John", LastName: "Doe"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, StartedAt: time.Now(), Status: QuestionApproved})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 1})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Donation?", Status: QuestionApproved})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Coffee?", Status: QuestionApproved})
+	schedule, err := newRotationSchedule("@every 1h", time.Local, "")
+	if err != nil {
+		t.Fatal("Can't parse schedule:", err)
+	}
+	s := newQuestionScheduler(schedule)
+
+	if _, err := s.Schedule(3, time.Now().Add(-time.Minute)); err != errScheduleInPast {
+		t.Fatal("Question scheduled in the past:", err)
+	}
+	if _, err := s.Schedule(3, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal("Can't schedule question:", err)
+	}
+	if _, err := s.Skip(); err != nil {
+		t.Fatal("Can't skip question:", err)
+	}
+	if q, err := GetCurrentQuestion(); err != nil || q.ID != 2 {
+		t.Fatal("Scheduled question picked:", q, err)
+	}
+	if u, err := GetUser(1); err != nil || u.Points != 0 {
+		t.Fatal("Skipped question scored:", u, err)
+	}
+
+	s.tick(time.Now())
+	if q, err := GetCurrentQuestion(); err != nil || q.ID != 2 {
+		t.Fatal("Question started before its time:", q, err)
+	}
+	s.tick(time.Now().Add(2 * time.Minute))
+	if q, err := GetCurrentQuestion(); err != nil || q.ID != 3 {
+		t.Fatal("Scheduled question not started:", q, err)
+	}
+
+	req := newRequest(t, "POST", "/admin/questions/next", nil)
+	if resp := DoRequest(req); resp.Code != http.StatusUnauthorized {
+		t.Fatal("Admin endpoint without token:", resp.Code)
+	}
+	adminAPIToken = "legitAdminToken42"
+	req.Header.Set("Authorization", "Bearer legitAdminToken42")
+	if resp := DoRequest(req); resp.Code != http.StatusConflict {
+		t.Fatal("Rotated without question:", resp.Code, resp.Body.String())
+	}
+}
+
+func TestAdminRotationDuringReveal(t *testing.T) {
+	defer teardown()
+	defer func() { adminAPIToken = ""; revealDuration = 0 }()
+	adminAPIToken = "legitAdminToken42"
+	revealDuration = time.Hour
+	db.CreateUser(&User{FirstName: "John", LastName: "Doe"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, StartedAt: time.Now(), Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "Yes"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Donation?", Status: QuestionApproved})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Coffee?", Status: QuestionApproved})
+
+	req := newRequest(t, "POST", "/admin/questions/next", nil)
+	req.Header.Set("Authorization", "Bearer legitAdminToken42")
+	resp := DoRequest(req)
+	var next GetCurrentQuestionAnswer
+	if err := json.Unmarshal(resp.Body.Bytes(), &next); err != nil || resp.Code != http.StatusOK {
+		t.Fatal("Can't rotate question:", resp.Code, resp.Body.String())
+	}
+	if next.Question.ID == 1 || next.Reveal != nil || !next.Question.StartedAt.After(time.Now()) {
+		t.Fatal("Rotated question not returned:", resp.Body.String())
+	}
+	req = newRequest(t, "POST", "/admin/questions/skip", nil)
+	req.Header.Set("Authorization", "Bearer legitAdminToken42")
+	if resp := DoRequest(req); resp.Code != http.StatusConflict {
+		t.Fatal("Rotated during the reveal:", resp.Code, resp.Body.String())
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	for key, value := range map[string]string{"SCORE_BASE": "ten", "REVEAL_DURATION": "15", "QUESTION_REFRESH_RATE": "1 hour", "SEASON_PERIOD": "year"} {
+		os.Setenv(key, value)
+		err := loadConfig()
+		os.Unsetenv(key)
+		if err == nil || !strings.Contains(err.Error(), value) {
+			t.Fatal("Invalid config loaded:", key, err)
+		}
+	}
+	if scoreBase != 10 || revealDuration != 0 || seasonPeriod != "" {
+		t.Fatal("Config changed by an invalid one:", scoreBase, revealDuration, seasonPeriod)
+	}
+}
+
+func TestRotationSchedule(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	schedule, err := newRotationSchedule("0 9-18 * * 1-5", paris, "12:00-14:00")
+	if err != nil {
+		t.Fatal("Can't parse schedule:", err)
+	}
+	tests := []struct {
+		now      time.Time
+		next     time.Time
+		offHours bool
+	}{
+		{time.Date(2017, 3, 1, 10, 30, 0, 0, paris), time.Date(2017, 3, 1, 11, 0, 0, 0, paris), false},
+		{time.Date(2017, 3, 1, 11, 30, 0, 0, paris), time.Date(2017, 3, 1, 14, 0, 0, 0, paris), false},
+		{time.Date(2017, 3, 1, 12, 30, 0, 0, paris), time.Date(2017, 3, 1, 14, 0, 0, 0, paris), true},
+		{time.Date(2017, 3, 3, 20, 0, 0, 0, paris), time.Date(2017, 3, 6, 9, 0, 0, 0, paris), true},
+		{time.Date(2017, 3, 3, 20, 0, 0, 0, time.UTC), time.Date(2017, 3, 6, 9, 0, 0, 0, paris), true},
+	}
+	for _, test := range tests {
+		if next := schedule.Next(test.now); !next.Equal(test.next) {
+			t.Fatal("Invalid next rotation:", test.now, next)
+		}
+		if offHours := schedule.OffHours(test.now); offHours != test.offHours {
+			t.Fatal("Invalid off hours:", test.now, offHours)
+		}
+	}
+
+	schedule, err = newRotationSchedule("@every 1h", time.UTC, "19:00-08:00")
+	if err != nil {
+		t.Fatal("Can't parse schedule:", err)
+	}
+	now := time.Date(2017, 3, 1, 18, 30, 0, 0, time.UTC)
+	if next := schedule.Next(now); next.Before(time.Date(2017, 3, 2, 8, 0, 0, 0, time.UTC)) || !schedule.OffHours(next.Add(-time.Hour)) {
+		t.Fatal("Rotation during quiet hours:", next)
+	}
+	if _, err := newRotationSchedule("@every 1h", time.Local, "7pm-8am"); err == nil {
+		t.Fatal("Invalid quiet hours parsed")
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	store, err := openSQLStore("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Can't open sqlite database:", err)
+	}
+	defer store.Close()
+	if err := store.MigrateUp(); err != nil {
+		t.Fatal("Can't migrate up:", err)
+	}
+	statuses, err := store.MigrationStatus()
+	if err != nil {
+		t.Fatal("Can't get migration status:", err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt.IsZero() {
+			t.Fatal("Migration not applied:", status.Version, status.Name)
+		}
+	}
+	for range migrations {
+		if err := store.MigrateDown(); err != nil {
+			t.Fatal("Can't migrate down:", err)
+		}
+	}
+	if store.db.HasTable(&User{}) {
+		t.Fatal("Table users not dropped")
+	}
+	if err := store.MigrateUp(); err != nil {
+		t.Fatal("Can't migrate up again:", err)
+	}
+	if err := store.CreateUser(&User{SlackID: "UD10923"}); err != nil {
+		t.Fatal("Can't create user:", err)
+	}
+
+	// The questions asked before the migration 8 ended when the next one started.
+	for range migrations[1:] {
+		if err := store.MigrateDown(); err != nil {
+			t.Fatal("Can't migrate down:", err)
+		}
+	}
+	started := time.Now().Add(-time.Hour)
+	store.db.Exec("INSERT INTO questions (user_id, sentence, started_at) VALUES (1, 'Help?', ?), (1, 'Donation?', ?)", started, started.Add(time.Minute))
+	store.db.Exec("INSERT INTO answer_entries (user_id, question_id, answer_id) VALUES (1, 1, 1), (1, 1, 2), (1, 2, 3)")
+	store.db.Exec("UPDATE users SET points = 30")
+	if err := store.MigrateUp(); err != nil {
+		t.Fatal("Can't migrate up from 1:", err)
+	}
+	// Only the last answer of each user to each question is kept by the migration 2.
+	if entries, err := store.GetAnswerEntriesByQuestionID(1); err != nil || len(entries) != 1 || entries[0].AnswerID != 2 {
+		t.Fatal("Duplicate answer entries kept:", entries, err)
+	}
+	q, err := store.GetQuestion(1)
+	if err != nil || !q.EndedAt.Equal(started.Add(time.Minute)) {
+		t.Fatal("Asked question not ended:", q, err)
+	}
+	if q, err := store.GetCurrentQuestion(); err != nil || q.ID != 2 || !q.EndedAt.IsZero() {
+		t.Fatal("Current question ended:", q, err)
+	}
+	// The points given before the migration 12 are recorded.
+	if events, _, err := store.GetPointEvents(1, 0, 10); err != nil || len(events) != 1 || events[0].Points != 30 || events[0].Reason != ReasonLegacy {
+		t.Fatal("Legacy points not recorded:", events, err)
+	}
+}
+
+func TestAnnounceRotation(t *testing.T) {
+	defer teardown()
+	defer func() { slackAnnounceChannel = "" }()
+	slackAnnounceChannel = "C2147483705"
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, StartedAt: time.Now(), Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "Yes"})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "No"})
+	db.SaveAnswerEntry(&AnswerEntry{QuestionID: 1, AnswerID: 1, UserID: 1})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Donation?", RightAnswerID: 3, Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 2, Sentence: "Sure"})
+	db.CreateAnswer(&Answer{QuestionID: 2, Sentence: "Never"})
+	if err := nextQuestion(); err != nil {
+		t.Fatal("Can't execute next question:", err)
+	}
+	messages := fakeSlack.Messages()
+	if len(messages) != 1 {
+		t.Fatal("Wrong posted messages number:", len(messages))
+	}
+	text := "Time's up! The answer to \"Help?\" was *Yes*.\n1 of 1 people got it right.\n\nNext Question from John Doe:\nDonation?\n1. Sure, 2. Never"
+	if messages[0].Channel != slackAnnounceChannel || messages[0].Text != text {
+		t.Fatalf("Invalid posted message: %q %q", messages[0].Channel, messages[0].Text)
+	}
+}
+
+func TestAchievements(t *testing.T) {
+	defer teardown()
+	defer func() { slackAchievementsChannel = "" }()
+	slackAchievementsChannel = "C2147483706"
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe"})
+	db.CreateUser(&User{SlackID: "UD10924", FirstName: "Jane", LastName: "Roe"})
+	db.CreateQuestion(&Question{UserID: 2, Sentence: "Help?", RightAnswerID: 1, StartedAt: time.Now(), Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "Yes"})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "No"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Donation?", RightAnswerID: 3, Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 2, Sentence: "Sure"})
+	db.CreateAnswer(&Answer{QuestionID: 2, Sentence: "Never"})
+	john, _ := db.GetUser(1)
+	getCommandTVResponse(&SlackCommandRequest{Text: "answer 2"}, john)
+	getCommandTVResponse(&SlackCommandRequest{Text: "answer 2"}, john)
+	if err := nextQuestion(); err != nil {
+		t.Fatal("Can't execute next question:", err)
+	}
+
+	want := map[string]bool{
+		":trophy: <@UD10923> unlocked *Hello TV*: Answer a question.":              true,
+		":trophy: <@UD10924> unlocked *Stumper*: Ask a question nobody got right.": true,
+	}
+	var messages []SlackMessage
+	for deadline := time.Now().Add(time.Second); len(messages) < len(want); messages = fakeSlack.Messages() {
+		if time.Now().After(deadline) {
+			t.Fatal("Achievements not announced:", messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, message := range messages {
+		if message.Channel != slackAchievementsChannel || !want[message.Text] {
+			t.Fatalf("Invalid posted message: %q %q", message.Channel, message.Text)
+		}
+	}
+	stumper, _ := db.GetAchievementByCode("stumper")
+	if err := db.CreateUserAchievement(&UserAchievement{UserID: 2, AchievementID: stumper.ID}); err != errAchievementUnlocked {
+		t.Fatal("Achievement unlocked twice:", err)
+	}
+
+	resp := DoRequest(newRequest(t, "GET", "/users/2/achievements", nil))
+	var achievements []UnlockedAchievement
+	if err := json.Unmarshal(resp.Body.Bytes(), &achievements); err != nil || len(achievements) != 1 {
+		t.Fatal("Invalid achievements:", resp.Code, resp.Body.String())
+	}
+	if a := achievements[0]; a.Achievement.Code != "stumper" || a.UnlockedAt.IsZero() {
+		t.Fatal("Invalid achievement:", resp.Body.String())
+	}
+	if resp := DoRequest(newRequest(t, "GET", "/users/3/achievements", nil)); resp.Code != http.StatusNotFound {
+		t.Fatal("Achievements of unknown user:", resp.Code)
+	}
+
+	// A skipped question isn't a stumper.
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Again?", RightAnswerID: 5, Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 3, Sentence: "Yes"})
+	db.CreateAnswer(&Answer{QuestionID: 3, Sentence: "No"})
+	jane, _ := db.GetUser(2)
+	getCommandTVResponse(&SlackCommandRequest{Text: "answer 2"}, jane)
+	if _, err := rotateQuestion(0, false); err != nil {
+		t.Fatal("Can't skip question:", err)
+	}
+	if count, err := db.CountStumpers(1); err != nil || count != 0 {
+		t.Fatal("Skipped question counted as stumper:", count, err)
+	}
+}
+
 func TestSlackCommandHelp(t *testing.T) {
 	defer teardown()
 	params := fmt.Sprintf("token=%s&user_id=UD10923&command=tv&text=help&response_url=http://localhost:4242/commands/1234/5500", slackCommandToken)
@@ -181,6 +1153,45 @@ func TestSlackCommandHelp(t *testing.T) {
 	}
 }
 
+func TestSlackSignature(t *testing.T) {
+	defer teardown()
+	defer func() { slackLegacyTokens = true }()
+	slackLegacyTokens = false
+	body := "user_id=UD10923&command=tv&text=help&response_url=http://localhost:4242/commands/1234/5500"
+	now := time.Now().Unix()
+	tests := []struct {
+		timestamp int64
+		signature string
+		status    int
+	}{
+		{now, "", http.StatusOK},
+		{now - 60, "", http.StatusOK},
+		{now, "v0=0123456789abcdef", http.StatusUnauthorized},
+		{now - 3600, "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		timestamp := strconv.FormatInt(test.timestamp, 10)
+		signature := test.signature
+		if signature == "" {
+			signature = "v0=" + hex.EncodeToString(slackSignature(timestamp, []byte(body)))
+		}
+		req := newRequest(t, "POST", "/slack/commands/tv", bytes.NewBufferString(body))
+		req.Header.Set(ContentType, ContentFormURLEncoded)
+		req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		req.Header.Set("X-Slack-Signature", signature)
+		resp := DoRequest(req)
+		if resp.Code != test.status {
+			t.Fatal("Invalid response:", test.timestamp, test.signature, resp.Code, resp.Body.String())
+		}
+	}
+	params := fmt.Sprintf("token=%s&%s", slackCommandToken, body)
+	req := newRequest(t, "POST", "/slack/commands/tv", bytes.NewBufferString(params))
+	req.Header.Set(ContentType, ContentFormURLEncoded)
+	if resp := DoRequest(req); resp.Code != http.StatusUnauthorized {
+		t.Fatal("Legacy token accepted while disabled:", resp.Code)
+	}
+}
+
 func TestSlackCommandQuestion(t *testing.T) {
 	defer teardown()
 	bodies := []string{
@@ -197,10 +1208,179 @@ func TestSlackCommandQuestion(t *testing.T) {
 	}
 }
 
+func TestSlackCommandModeration(t *testing.T) {
+	defer teardown()
+	defer func() { moderatorSlackIDs = map[string]bool{} }()
+	user, err := GetUserBySlackID("UD10923")
+	if err != nil {
+		t.Fatal("Can't get user:", err)
+	}
+	for _, text := range []string{"question Alive? 1 true false", "question Dead? 2 true false"} {
+		getCommandTVResponse(&SlackCommandRequest{Text: text}, user)
+	}
+	if resp := getCommandTVResponse(&SlackCommandRequest{Text: "pending"}, user); resp.Text != "Error: You're not allowed to run \"pending\", it's for the moderators." {
+		t.Fatal("Non moderator allowed:", resp.Text)
+	}
+	if err := nextQuestion(); err != errNoQuestionAvailable {
+		t.Fatal("Pending question rotated:", err)
+	}
+
+	moderatorSlackIDs = map[string]bool{"UD10923": true}
+	resp := getCommandTVResponse(&SlackCommandRequest{Text: "pending"}, user)
+	if resp.Text != "Pending questions:\n#1 Alive?\n1. true, 2. false (right answer: true)\n#2 Dead?\n1. true, 2. false (right answer: false)\n" {
+		t.Fatalf("Invalid pending questions: %q", resp.Text)
+	}
+	getCommandTVResponse(&SlackCommandRequest{Text: "approve 1"}, user)
+	getCommandTVResponse(&SlackCommandRequest{Text: "reject 2 Too sad"}, user)
+	messages := fakeSlack.Messages()
+	if len(messages) != 2 || messages[0].Channel != "UD10923" || messages[1].Text != "Your question \"Dead?\" has been rejected: Too sad" {
+		t.Fatal("Invalid direct messages:", messages)
+	}
+	if err := nextQuestion(); err != nil {
+		t.Fatal("Can't execute next question:", err)
+	}
+	if q, err := GetCurrentQuestion(); err != nil || q.ID != 1 {
+		t.Fatal("Invalid current question:", q, err)
+	}
+}
+
+func TestSlackCommandScheduleTimezone(t *testing.T) {
+	defer teardown()
+	defer func(location *time.Location) { adminSlackIDs = map[string]bool{}; questionLocation = location }(questionLocation)
+	questionLocation = time.FixedZone("UTC+14", 14*60*60)
+	adminSlackIDs = map[string]bool{"UD10923": true}
+	admin, err := GetUserBySlackID("UD10923")
+	if err != nil {
+		t.Fatal("Can't get user:", err)
+	}
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", Status: QuestionApproved})
+	resp := getCommandTVResponse(&SlackCommandRequest{Text: "schedule 1 2030-01-02 09:00"}, admin)
+	if resp.Text != "Question #1 scheduled for 2030-01-02 09:00." {
+		t.Fatal("Can't schedule question:", resp.Text)
+	}
+	if q, err := db.GetQuestion(1); err != nil || !q.ScheduledAt.Equal(time.Date(2030, 1, 1, 19, 0, 0, 0, time.UTC)) {
+		t.Fatal("Question scheduled out of the questions timezone:", q, err)
+	}
+	if from, err := parseDate("2030-01-02"); err != nil || !from.Equal(time.Date(2030, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatal("Date out of the questions timezone:", from, err)
+	}
+}
+
+func TestSlackCommandRoles(t *testing.T) {
+	defer teardown()
+	defer func() { adminSlackIDs = map[string]bool{} }()
+	admin, err := GetUserBySlackID("UD10923")
+	if err != nil {
+		t.Fatal("Can't get user:", err)
+	}
+	db.CreateImage(&Image{URL: "http://localhost/1.png"})
+	db.CreateImage(&Image{URL: "http://localhost/2.png"})
+	if resp := getCommandTVResponse(&SlackCommandRequest{Text: "delete-image"}, admin); resp.Text != "Error: You're not allowed to run \"delete-image\", it's for the admins." {
+		t.Fatal("Non admin allowed:", resp.Text)
+	}
+
+	adminSlackIDs = map[string]bool{"UD10923": true}
+	if resp := getCommandTVResponse(&SlackCommandRequest{Text: "delete-image"}, admin); resp.Text != "Image 2 deleted." {
+		t.Fatal("Can't delete image:", resp.Text)
+	}
+	for _, text := range []string{"delete-image 0", "delete-image 2"} {
+		if resp := getCommandTVResponse(&SlackCommandRequest{Text: text}, admin); !strings.HasPrefix(resp.Text, "Error: ") {
+			t.Fatal("Missing image deleted:", text, resp.Text)
+		}
+	}
+	if image, err := GetLastImage(); err != nil || image.ID != 1 {
+		t.Fatal("Invalid last image:", image, err)
+	}
+	if err := db.DeleteImage(2); err != gorm.ErrRecordNotFound {
+		t.Fatal("Missing image deleted:", err)
+	}
+	if err := db.DeleteMessage(1); err != gorm.ErrRecordNotFound {
+		t.Fatal("Missing message deleted:", err)
+	}
+	if resp := getCommandTVResponse(&SlackCommandRequest{Text: "role <@UD10924|jane> moderator"}, admin); resp.Text != "Jane Roe is now moderator." {
+		t.Fatal("Can't set role:", resp.Text)
+	}
+	moderator, err := GetUserBySlackID("UD10924")
+	if err != nil {
+		t.Fatal("Can't get user:", err)
+	}
+	if !hasRole(moderator, RoleModerator) || hasRole(moderator, RoleAdmin) {
+		t.Fatal("Invalid role:", userRole(moderator))
+	}
+	player := &User{SlackID: "UD10925", Points: 42}
+	db.CreateUser(player)
+	if resp := getCommandTVResponse(&SlackCommandRequest{Text: "reset-points"}, admin); resp.Text != "Points reset." {
+		t.Fatal("Can't reset points:", resp.Text)
+	}
+	if player, err := GetUser(player.ID); err != nil || player.Points != 0 {
+		t.Fatal("Points not reset:", player, err)
+	}
+}
+
+func TestTeams(t *testing.T) {
+	defer teardown()
+	defer func() { adminSlackIDs = map[string]bool{}; teamProfileField = "" }()
+	adminSlackIDs = map[string]bool{"UD10923": true}
+	admin, err := GetUserBySlackID("UD10923")
+	if err != nil {
+		t.Fatal("Can't get user:", err)
+	}
+	if resp := getCommandTVResponse(&SlackCommandRequest{Text: "team <@UD10923|john> Sales"}, admin); resp.Text != "John Doe is in Sales." {
+		t.Fatal("Can't set team:", resp.Text)
+	}
+	if resp := getCommandTVResponse(&SlackCommandRequest{Text: "team-sync Engineering <!subteam^S0614TZR7|@eng>"}, admin); resp.Text != "1 teams synced." {
+		t.Fatal("Can't sync team:", resp.Text)
+	}
+	jane, err := db.GetUserBySlackID("UD10924")
+	if err != nil || jane.TeamID != 2 {
+		t.Fatal("User group member not in team:", jane, err)
+	}
+	db.AddPointEvent(&PointEvent{UserID: admin.ID, Points: 30, Reason: ReasonAdjustment})
+	db.AddPointEvent(&PointEvent{UserID: jane.ID, Points: 40, Reason: ReasonAdjustment})
+
+	for scoring, want := range map[string][]string{"total": {"Engineering", "Sales"}, "per_capita": {"Sales", "Engineering"}} {
+		resp := DoRequest(newRequest(t, "GET", "/teams/top?scoring="+scoring, nil))
+		var standings []TeamStanding
+		if err := json.Unmarshal(resp.Body.Bytes(), &standings); err != nil || len(standings) != 2 {
+			t.Fatal("Invalid teams leaderboard:", resp.Code, resp.Body.String())
+		}
+		for i, name := range want {
+			if standings[i].Team.Name != name || standings[i].Rank != i+1 {
+				t.Fatal("Invalid teams leaderboard:", scoring, resp.Body.String())
+			}
+		}
+		if standings[0].Members+standings[1].Members != 3 {
+			t.Fatal("Invalid team members:", resp.Body.String())
+		}
+	}
+
+	if member, err := GetUserBySlackID("UD10926"); err != nil || member.TeamID != 2 {
+		t.Fatal("User group member not in team:", member, err)
+	}
+	postTestEvent(t, fmt.Sprintf(`{"token":%q,"type":"event_callback","event_id":"EvTeam1","event":{"type":"subteam_members_changed","subteam_id":"S0614TZR7","removed_users":["UD10926"]}}`, slackCommandToken))
+	if member, err := GetUserBySlackID("UD10926"); err != nil || member.TeamID != 0 {
+		t.Fatal("Removed member still in team:", member, err)
+	}
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, Status: QuestionApproved})
+	resp := getCommandTVResponse(&SlackCommandRequest{Text: "status"}, admin)
+	if !strings.HasSuffix(resp.Text, "\nTeams:\n1. Engineering: 40 points, 40 per member\n2. Sales: 30 points, 30 per member\n") {
+		t.Fatal("Invalid teams status:", resp.Text)
+	}
+
+	teamProfileField = "Xf0DEPT"
+	postTestEvent(t, fmt.Sprintf(`{"token":%q,"type":"event_callback","event_id":"EvTeam2","event":{"type":"user_change","user":{"id":"UD10923","profile":{"first_name":"John","last_name":"Doe","fields":{"Xf0DEPT":{"value":"Marketing"}}}}}}`, slackCommandToken))
+	if team, err := db.GetTeamByName("Marketing"); err != nil || team.ID != 3 {
+		t.Fatal("Team of the profile not created:", team, err)
+	}
+	if user, err := db.GetUser(admin.ID); err != nil || user.TeamID != 3 {
+		t.Fatal("User not in the team of the profile:", user, err)
+	}
+}
+
 func TestSlackCommandAnswer(t *testing.T) {
 	defer teardown()
-	db.Create(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1})
-	db.Create(&Answer{QuestionID: 1, Sentence: "Yes"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "Yes"})
 	bodies := []string{
 		fmt.Sprintf("token=%s&user_id=UD10923&command=tv&text=answer&response_url=http://localhost:4242/commands/1234/5700", slackCommandToken),
 		fmt.Sprintf("token=%s&user_id=UD10923&command=tv&text=answer 1&response_url=http://localhost:4242/commands/1234/5701", slackCommandToken),
@@ -218,10 +1398,11 @@ func TestSlackCommandAnswer(t *testing.T) {
 
 func TestSlackCommandStatus(t *testing.T) {
 	defer teardown()
-	db.Create(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe", Points: 42})
-	db.Create(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1})
-	db.Create(&Answer{QuestionID: 1, Sentence: "Yes"})
-	db.Create(&Answer{QuestionID: 1, Sentence: "No"})
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe", Points: 42})
+	db.AddPointEvent(&PointEvent{UserID: 1, Points: 12, Reason: ReasonAdjustment})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "Yes"})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "No"})
 	params := fmt.Sprintf("token=%s&user_id=UD10923&command=tv&text=status&response_url=http://localhost:4242/commands/1234/5800", slackCommandToken)
 	req := newRequest(t, "POST", "/slack/commands/tv", bytes.NewBufferString(params))
 	req.Header.Set(ContentType, ContentFormURLEncoded)
@@ -231,9 +1412,60 @@ func TestSlackCommandStatus(t *testing.T) {
 	}
 }
 
+func TestSlackCommandQuiz(t *testing.T) {
+	defer teardown()
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe"})
+	db.CreateQuestion(&Question{UserID: 1, Sentence: "Help?", RightAnswerID: 1, Status: QuestionApproved})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "Yes"})
+	db.CreateAnswer(&Answer{QuestionID: 1, Sentence: "No"})
+	params := fmt.Sprintf("token=%s&user_id=UD10923&command=tv&text=quiz&response_url=http://localhost:4242/commands/1234/6000", slackCommandToken)
+	req := newRequest(t, "POST", "/slack/commands/tv", bytes.NewBufferString(params))
+	req.Header.Set(ContentType, ContentFormURLEncoded)
+	resp := DoRequest(req)
+	if resp.Code != http.StatusOK {
+		t.Fatal("Invalid response:", resp.Code, resp.Body.String())
+	}
+	slackResp, err := getQuestionMessage(&Question{Model: gorm.Model{ID: 1}, UserID: 1, Sentence: "Help?"})
+	if err != nil {
+		t.Fatal("Can't get question message:", err)
+	}
+	if len(slackResp.Blocks) != 2 || len(slackResp.Blocks[1].Elements) != 2 {
+		t.Fatal("Invalid question blocks:", slackResp.Blocks)
+	}
+	button := slackResp.Blocks[1].Elements[1]
+	payload, _ := json.Marshal(&SlackInteraction{
+		Token:       slackCommandToken,
+		Type:        "block_actions",
+		ResponseURL: "http://localhost:4242/actions/1234/6001",
+		User:        SlackUser{ID: "UD10923"},
+		Actions:     []SlackAction{{ActionID: button.ActionID, Value: button.Value}},
+	})
+	params = fmt.Sprintf("payload=%s", url.QueryEscape(string(payload)))
+	req = newRequest(t, "POST", "/slack/interactions", bytes.NewBufferString(params))
+	req.Header.Set(ContentType, ContentFormURLEncoded)
+	responded := len(fakeSlack.Responses())
+	resp = DoRequest(req)
+	if resp.Code != http.StatusOK {
+		t.Fatal("Invalid response:", resp.Code, resp.Body.String())
+	}
+	if count, _ := db.CountAnswerEntries(1); count != 1 {
+		t.Fatal("Answer not added:", count)
+	}
+	var responses []SlackCommandResponse
+	for deadline := time.Now().Add(time.Second); len(responses) <= responded; responses = fakeSlack.Responses() {
+		if time.Now().After(deadline) {
+			t.Fatal("Question message not updated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if last := responses[len(responses)-1]; !last.ReplaceOriginal || len(last.Blocks) != 3 {
+		t.Fatal("Question message not updated:", last)
+	}
+}
+
 func TestSlackCommandImage(t *testing.T) {
 	defer teardown()
-	db.Create(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe", Points: 42})
+	db.CreateUser(&User{SlackID: "UD10923", FirstName: "John", LastName: "Doe", Points: 42})
 	imageURL := "http://localhost.com/image.png"
 	params := fmt.Sprintf("token=%s&user_id=UD10923&command=tv&text=image %s&response_url=http://localhost:4242/commands/1234/5900", slackCommandToken, imageURL)
 	req := newRequest(t, "POST", "/slack/commands/tv", bytes.NewBufferString(params))
@@ -254,31 +1486,51 @@ func TestSlackCommandImage(t *testing.T) {
 	}
 }
 
-func initSlackServer() {
+func TestSlackWebClient(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if auth := r.Header.Get("Authorization"); auth != "Bearer legitAPIToken42" {
+			t.Error("Invalid authorization:", auth)
+		}
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			renderJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "channel": "C2147483705", "ts": "1355517523.000005"})
+		}
+	}))
+	defer server.Close()
+	client := newSlackWebClient(server.URL, "legitAPIToken42")
+	client.backoff = time.Millisecond
+	msg := &SlackMessage{Channel: "C2147483705", Text: "Hello"}
+	start := time.Now()
+	if err := client.PostMessage(msg); err != nil {
+		t.Fatal("Can't post message:", err)
+	}
+	if calls != 3 || msg.TS != "1355517523.000005" {
+		t.Fatal("Invalid post message:", calls, msg.TS)
+	}
+	if time.Since(start) < time.Second {
+		t.Fatal("Retry-After not honoured")
+	}
+	client.maxRetries = 0
+	calls = 1
+	if err := client.PostMessage(msg); err == nil {
+		t.Fatal("Error not returned after the last retry")
+	}
+}
+
+func initSlackClient() {
 	slackCommandToken = "legitCommandToken42"
 	slackOutgoingToken = "legitOutgoingToken42"
-	slackAPIToken = "legitAPIToken42"
-	slackURL = "http://localhost:4242"
-	m := martini.Classic()
-	m.Get("/api/users.info", slackUserInfo)
-	m.Post("/commands/1234/5500", slackCommandHandler(commandTVUsage))
-	m.Post("/commands/1234/5600", slackCommandHandler(commandTVUsage))
-	m.Post("/commands/1234/5601", slackCommandHandler("Your question has been submitted. Thank You!"))
-	m.Post("/commands/1234/5700", slackCommandHandler(commandTVUsage))
-	m.Post("/commands/1234/5701", slackCommandHandler("Answer Added.\nHelp? Yes"))
-	m.Post("/commands/1234/5702", slackCommandHandler("Invalid answer index.\nThere is 1 possible answers.\nSee help and status for more details"))
-	m.Post("/commands/1234/5800", slackCommandHandler("Question from John Doe:\nHelp?\n1. Yes, 2. No\n\nTop:\nJohn Doe: 42 points\n"))
-	m.Post("/commands/1234/5900", slackCommandHandler("Image added successfully!"))
-	go m.RunOnAddr(":4242")
-}
-
-func slackUserInfo(w http.ResponseWriter, r *http.Request) {
-	renderJSON(w, http.StatusOK, struct {
-		OK   bool      `json:"ok"`
-		User SlackUser `json:"user"`
-	}{
-		OK: true,
-		User: SlackUser{
+	slackSigningSecret = "legitSigningSecret42"
+	slackLegacyTokens = true
+	fakeSlack.users = map[string]SlackUser{
+		"UD10923": {
 			ID: "UD10923",
 			Profile: SlackProfile{
 				FirstName: "John",
@@ -286,25 +1538,37 @@ func slackUserInfo(w http.ResponseWriter, r *http.Request) {
 				ImageURL:  "http://localhost/image.jpg",
 			},
 		},
-	})
-}
-func slackCommandHandler(text string) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if contentType := r.Header.Get(ContentType); contentType != ContentJSON {
-			renderJSON(w, http.StatusBadRequest, fmt.Sprint("Invalid content type: ", contentType))
-			return
-		}
-		var slackResp SlackCommandResponse
-		if err := json.NewDecoder(r.Body).Decode(&slackResp); err != nil {
-			renderJSON(w, http.StatusBadRequest, fmt.Sprint("Can't decode to JSON: ", err))
-			return
-		}
-		if slackResp.Text != text {
-			renderJSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid response text: %q != %q", slackResp.Text, text))
-			return
-		}
-		renderJSON(w, http.StatusOK, "OK")
+		"UD10924": {
+			ID: "UD10924",
+			Profile: SlackProfile{
+				FirstName: "Jane",
+				LastName:  "Roe",
+			},
+		},
+		"UD10926": {
+			ID: "UD10926",
+			Profile: SlackProfile{
+				FirstName: "Jim",
+				LastName:  "Poe",
+			},
+		},
+	}
+	fakeSlack.usergroups = map[string][]string{
+		"S0614TZR7": {"UD10924", "UD10926"},
 	}
+	fakeSlack.expectedResponses = map[string]string{
+		"http://localhost:4242/commands/1234/5500": commandTVUsage,
+		"http://localhost:4242/commands/1234/5600": commandTVUsage,
+		"http://localhost:4242/commands/1234/5601": "Your question has been submitted. Thank You!",
+		"http://localhost:4242/commands/1234/5700": commandTVUsage,
+		"http://localhost:4242/commands/1234/5701": "Answer Added.\nHelp? Yes",
+		"http://localhost:4242/commands/1234/5702": "Invalid answer index.\nThere is 1 possible answers.\nSee help and status for more details",
+		"http://localhost:4242/commands/1234/5800": "Question from John Doe:\nHelp?\n1. Yes, 2. No\n\nTop of the week:\n1. John Doe: 12 points\n",
+		"http://localhost:4242/commands/1234/5900": "Image added successfully!",
+		"http://localhost:4242/commands/1234/6000": "Question from John Doe:\nHelp?",
+		"http://localhost:4242/actions/1234/6001":  "Question from John Doe:\nHelp?",
+	}
+	slackClient = fakeSlack
 }
 
 func newRequest(t *testing.T, method, urlStr string, body io.Reader) *http.Request {
@@ -322,8 +1586,11 @@ func DoRequest(req *http.Request) *httptest.ResponseRecorder {
 }
 
 func teardown() {
-	db.DropTable(&Answer{}, &AnswerEntry{}, &Image{}, &Message{}, &Question{}, &User{})
-	db.CreateTable(&Answer{}, &AnswerEntry{}, &Image{}, &Message{}, &Question{}, &User{})
+	if err := db.Reset(); err != nil {
+		log.Fatal("Can't reset database:", err)
+	}
+	fakeSlack.Reset()
+	userCache.Reset()
 }
 
 func addTestMessage(t *testing.T, userID string, text string) {
@@ -335,3 +1602,127 @@ func addTestMessage(t *testing.T, userID string, text string) {
 		t.Fatal("Message not added:", resp1.Code)
 	}
 }
+
+func postTestEvent(t *testing.T, body string) *httptest.ResponseRecorder {
+	req := newRequest(t, "POST", "/slack/events", bytes.NewBufferString(body))
+	req.Header.Set(ContentType, ContentJSON)
+	resp := DoRequest(req)
+	if resp.Code != http.StatusOK {
+		t.Fatal("Event not handled:", resp.Code, resp.Body.String())
+	}
+	return resp
+}
+
+// readTestEvent reads the next event of a text/event-stream.
+func readTestEvent(t *testing.T, r *bufio.Reader) (id, eventType, data string) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal("Can't read event:", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && eventType != "":
+			return id, eventType, data
+		case strings.HasPrefix(line, "id: "):
+			id = line[len("id: "):]
+		case strings.HasPrefix(line, "event: "):
+			eventType = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			data = line[len("data: "):]
+		}
+	}
+}
+
+// fakeSlackClient is a SlackClient recording the calls to the Web API.
+// Respond fails if the text differs from the one expected for the response_url.
+type fakeSlackClient struct {
+	mu                sync.Mutex
+	users             map[string]SlackUser
+	usergroups        map[string][]string
+	expectedResponses map[string]string
+	messages          []SlackMessage
+	responses         []SlackCommandResponse
+	userInfoCalls     int
+}
+
+func (c *fakeSlackClient) GetUserInfo(userID string) (*SlackUser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.userInfoCalls++
+	user, ok := c.users[userID]
+	if !ok {
+		return nil, &SlackAPIError{Method: "users.info", Code: "user_not_found"}
+	}
+	return &user, nil
+}
+
+func (c *fakeSlackClient) GetUsergroupMembers(usergroupID string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	members, ok := c.usergroups[usergroupID]
+	if !ok {
+		return nil, &SlackAPIError{Method: "usergroups.users.list", Code: "no_such_subteam"}
+	}
+	return members, nil
+}
+
+func (c *fakeSlackClient) PostMessage(msg *SlackMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg.TS = fmt.Sprintf("1355517523.%06d", len(c.messages))
+	c.messages = append(c.messages, *msg)
+	return nil
+}
+
+func (c *fakeSlackClient) UpdateMessage(msg *SlackMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.messages {
+		if c.messages[i].Channel == msg.Channel && c.messages[i].TS == msg.TS {
+			c.messages[i] = *msg
+			return nil
+		}
+	}
+	return &SlackAPIError{Method: "chat.update", Code: "message_not_found"}
+}
+
+func (c *fakeSlackClient) Respond(responseURL string, resp *SlackCommandResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responses = append(c.responses, *resp)
+	if text, ok := c.expectedResponses[responseURL]; ok && resp.Text != text {
+		return fmt.Errorf("Invalid response text: %q != %q", resp.Text, text)
+	}
+	return nil
+}
+
+// Messages returns the posted messages.
+func (c *fakeSlackClient) Messages() []SlackMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SlackMessage(nil), c.messages...)
+}
+
+// Responses returns the responses sent to the response_urls.
+func (c *fakeSlackClient) Responses() []SlackCommandResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SlackCommandResponse(nil), c.responses...)
+}
+
+// UserInfoCalls returns how many times users.info was called.
+func (c *fakeSlackClient) UserInfoCalls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userInfoCalls
+}
+
+// Reset forgets the calls.
+func (c *fakeSlackClient) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+	c.responses = nil
+	c.userInfoCalls = 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const maxCategoryLength = 32
+
+// normalizeCategory returns the category in lower case, without the quotes of the Slack arguments.
+func normalizeCategory(category string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Trim(category, `"'`)))
+}
+
+// normalizeTags returns the comma separated tags in lower case, sorted and without duplicates.
+func normalizeTags(tags []string) string {
+	seen := make(map[string]bool)
+	var list []string
+	for _, tag := range tags {
+		for _, tag := range strings.Split(tag, ",") {
+			tag = normalizeCategory(tag)
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				list = append(list, tag)
+			}
+		}
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// splitTags returns the list of the comma separated tags.
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
+
+// validateCategory checks the category and the tags of a question.
+func validateCategory(category, tags string) error {
+	if len(category) > maxCategoryLength {
+		return fmt.Errorf("Category is too long maximum %d characters", maxCategoryLength)
+	}
+	for _, tag := range splitTags(tags) {
+		if len(tag) > maxCategoryLength {
+			return fmt.Errorf("Tag %q is too long maximum %d characters", tag, maxCategoryLength)
+		}
+	}
+	return nil
+}
+
+// parseQuestionFlags removes the --category and --tags flags from the arguments
+// of the question command, e.g. --category geography --tags=maps,europe.
+func parseQuestionFlags(args []string) (rest []string, category, tags string, err error) {
+	var tagList []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			rest = append(rest, arg)
+			continue
+		}
+		name, value := arg[2:], ""
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value = name[:j], name[j+1:]
+		} else if i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		switch name {
+		case "category":
+			category = normalizeCategory(value)
+		case "tags":
+			tagList = append(tagList, value)
+		default:
+			return nil, "", "", fmt.Errorf("Unknown flag --%s, valid flags: --category, --tags", name)
+		}
+	}
+	return rest, category, normalizeTags(tagList), nil
+}
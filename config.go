@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// questionLocation is the timezone of the questions set by QUESTION_TIMEZONE, local by default.
+// It gives the times of the rotation schedule, the days of the themes and the leaderboard periods.
+var questionLocation = time.Local
+
+// loadConfig parses the config of the web service set in the env: the timezone,
+// rotation schedule and policy of the questions, the period of the seasons, the scoring,
+// the reveal, and the sizes of the events buffer and the user cache.
+func loadConfig() error {
+	location := time.Local
+	if timezone := os.Getenv("QUESTION_TIMEZONE"); timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return fmt.Errorf("Invalid timezone %q: %v", timezone, err)
+		}
+	}
+	schedule, err := rotationScheduleFromEnv(location)
+	if err != nil {
+		return err
+	}
+	policy, err := rotationPolicyFromEnv(location)
+	if err != nil {
+		return err
+	}
+	period, err := seasonPeriodFromEnv()
+	if err != nil {
+		return err
+	}
+	ints := []struct {
+		key   string
+		value *int
+	}{
+		{"SCORE_BASE", &scoreBase},
+		{"SCORE_SPEED_BONUS", &scoreSpeedBonus},
+		{"SCORE_DIFFICULTY_FACTOR", &scoreDifficultyFactor},
+		{"SCORE_STREAK_BONUS", &scoreStreakBonus},
+		{"SCORE_STREAK_MAX", &scoreStreakMax},
+	}
+	values := make([]int, len(ints))
+	for j, i := range ints {
+		if values[j], err = envInt(i.key, *i.value); err != nil {
+			return err
+		}
+	}
+	speedWindow, err := envDuration("SCORE_SPEED_WINDOW", scoreSpeedWindow)
+	if err != nil {
+		return err
+	}
+	reveal, err := envDuration("REVEAL_DURATION", revealDuration)
+	if err != nil {
+		return err
+	}
+	eventsBufferSize, err := envInt("EVENTS_BUFFER_SIZE", 256)
+	if err != nil {
+		return err
+	}
+	cacheTTL, err := envDuration("SLACK_USER_CACHE_TTL", time.Hour)
+	if err != nil {
+		return err
+	}
+	cacheSize, err := envInt("SLACK_USER_CACHE_SIZE", 1024)
+	if err != nil {
+		return err
+	}
+	for j, i := range ints {
+		*i.value = values[j]
+	}
+	scoreSpeedWindow = speedWindow
+	revealDuration = reveal
+	questionLocation = location
+	scheduler = newQuestionScheduler(schedule)
+	questionPolicy = policy
+	seasonPeriod = period
+	events = newEventBroker(eventsBufferSize)
+	userCache = newUserProfileCache(cacheTTL, cacheSize)
+	return nil
+}
+
+// envDuration returns the duration set in the env variable key, or def if unset.
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s %q, must be a duration, e.g. 10m", key, value)
+	}
+	return d, nil
+}
+
+// envInt returns the integer set in the env variable key, or def if unset.
+func envInt(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s %q, must be an integer", key, value)
+	}
+	return i, nil
+}
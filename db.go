@@ -3,39 +3,197 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/jinzhu/gorm"
 )
 
-var db *gorm.DB
+var db Store
 
-// InitDB opens the database with the informations from the env.
-// Automigrate all the tables.
+// Store is the storage backend of the web service.
+// Every record lookup returns gorm.ErrRecordNotFound when nothing matches.
+type Store interface {
+	// Transaction runs fn against a store bound to a single transaction.
+	// The transaction is rolled back if fn returns an error.
+	Transaction(fn func(tx Store) error) error
+	// Reset removes every record from the store.
+	Reset() error
+	// Close releases the resources held by the store.
+	Close() error
+
+	// GetUsers returns every user by id.
+	GetUsers() ([]User, error)
+	GetUser(id uint) (*User, error)
+	GetUserBySlackID(slackID string) (*User, error)
+	GetUsersTop(count int) ([]User, error)
+	// GetUsersTopByCategory returns the users with the most right answers to the ended
+	// questions of the category, with their number as Points.
+	GetUsersTopByCategory(category string, count int) ([]User, error)
+	CreateUser(user *User) error
+	// SaveUserProfile creates the user or updates the profile of the user
+	// with the same SlackID. The stored user is loaded back into user.
+	SaveUserProfile(user *User) error
+	// AddPointEvent records the event and adds its points to the user.
+	AddPointEvent(event *PointEvent) error
+	SetUserStreak(id uint, streak uint) error
+	SetUserPoints(id uint, points uint) error
+	SetUserRole(id uint, role string) error
+	// SetUserTeam puts the user in the team, or in no team if teamID is 0.
+	SetUserTeam(id uint, teamID uint) error
+
+	// GetTeams returns every team by name.
+	GetTeams() ([]Team, error)
+	GetTeam(id uint) (*Team, error)
+	GetTeamByName(name string) (*Team, error)
+	GetTeamBySlackUsergroupID(usergroupID string) (*Team, error)
+	CreateTeam(team *Team) error
+	SaveTeam(team *Team) error
+
+	// GetPointEvents returns a page of the point events of the user, the last first,
+	// and the number of events on every page.
+	GetPointEvents(userID uint, offset, limit int) ([]PointEvent, int, error)
+	// SumPointEvents returns the sum of the points of the events from the time until to by user id.
+	// A zero to doesn't bound the events.
+	SumPointEvents(from, to time.Time) (map[uint]int, error)
+	// GetFirstRightAnswers returns the time of the first right answer scored from the time
+	// until to by user id. A zero to doesn't bound the answers.
+	GetFirstRightAnswers(from, to time.Time) (map[uint]time.Time, error)
+	CountPointEvents(userID uint, reason string) (int, error)
+
+	GetAchievement(id uint) (*Achievement, error)
+	GetAchievementByCode(code string) (*Achievement, error)
+	CreateAchievement(achievement *Achievement) error
+	SaveAchievement(achievement *Achievement) error
+	// GetUserAchievements returns the achievements unlocked by the user, the first unlocked first.
+	GetUserAchievements(userID uint) ([]UserAchievement, error)
+	// CreateUserAchievement returns errAchievementUnlocked if the user already has the achievement.
+	CreateUserAchievement(userAchievement *UserAchievement) error
+
+	GetSeason(id uint) (*Season, error)
+	// GetCurrentSeason returns the season not ended.
+	GetCurrentSeason() (*Season, error)
+	// GetPastSeasons returns the ended seasons, the last first.
+	GetPastSeasons() ([]Season, error)
+	CreateSeason(season *Season) error
+	SaveSeason(season *Season) error
+	// GetSeasonStandings returns the count first standings of the season by position,
+	// or all of them if count is 0.
+	GetSeasonStandings(seasonID uint, count int) ([]SeasonStanding, error)
+	CreateSeasonStanding(standing *SeasonStanding) error
+
+	GetQuestion(id uint) (*Question, error)
+	GetQuestionBySentence(sentence string) (*Question, error)
+	// GetCurrentQuestion returns the approved question started last.
+	// A question starting once the previous one is revealed isn't current yet.
+	GetCurrentQuestion() (*Question, error)
+	// GetUnstartedQuestions returns the approved questions not started yet.
+	GetUnstartedQuestions() ([]Question, error)
+	GetQuestionsByStatus(status string) ([]Question, error)
+	// GetQuestions returns a page of the approved questions matching the filter,
+	// the upcoming ones by id and the others the last started first,
+	// and the number of questions matching it on every page.
+	GetQuestions(filter QuestionFilter) ([]Question, int, error)
+	CreateQuestion(question *Question) error
+	SaveQuestion(question *Question) error
+	// CountStumpers returns the number of scored questions of the user which were
+	// answered, but by nobody right.
+	CountStumpers(userID uint) (int, error)
+
+	GetAnswersByQuestionID(questionID uint) ([]Answer, error)
+	CreateAnswer(answer *Answer) error
+
+	// SaveAnswerEntry creates the entry or replaces the answer previously
+	// given by the same user to the same question.
+	SaveAnswerEntry(entry *AnswerEntry) error
+	CountAnswerEntries(questionID uint) (int, error)
+	CountAnswerEntriesByUserID(userID uint) (int, error)
+	GetAnswerEntriesByQuestionID(questionID uint) ([]AnswerEntry, error)
+
+	GetMessages(fromID uint, count int) ([]Message, error)
+	GetMessageBySlackTS(channel, ts string) (*Message, error)
+	CountMessagesByUserID(userID uint) (int, error)
+	CreateMessage(message *Message) error
+	SaveMessage(message *Message) error
+	DeleteMessage(id uint) error
+
+	GetImage(id uint) (*Image, error)
+	GetLastImage() (*Image, error)
+	CreateImage(image *Image) error
+	DeleteImage(id uint) error
+}
+
+// InitDB opens the store selected by DB_DRIVER (mysql, sqlite3 or memory)
+// and applies the pending schema migrations unless DB_AUTO_MIGRATE is false.
+// DB_DSN overrides the data source name built from the env.
 func InitDB() {
 	var err error
-	user := os.Getenv("MYSQL_USER")
-	password := os.Getenv("MYSQL_PASSWORD")
-	dbname := os.Getenv("MYSQL_DATABASE")
-	sqlConnection := fmt.Sprintf("%s:%s@tcp(db:3306)/%s?charset=utf8&parseTime=True&loc=Local", user, password, dbname)
-	db, err = gorm.Open("mysql", sqlConnection)
+	db, err = openDB()
 	if err != nil {
 		log.WithFields(log.Fields{
-			"user":     user,
-			"password": password,
-			"dbname":   dbname,
-			"err":      err,
-		}).Fatal("Can't open mysql database")
-		log.Fatal(err)
+			"driver": dbDriver(),
+			"user":   os.Getenv("MYSQL_USER"),
+			"dbname": os.Getenv("MYSQL_DATABASE"),
+			"err":    err,
+		}).Fatal("Can't open database")
 	}
-	db.AutoMigrate(&Answer{}, &AnswerEntry{}, &Image{}, &Message{}, &Question{}, &User{})
 }
 
-// InsertOrUpdateDB inserts or updates the values in the database.
-func InsertOrUpdateDB(query, out interface{}) error {
-	if db.Where(query).First(out).RecordNotFound() {
-		return db.Create(out).Error
+// openDB opens the store set in the env and applies the pending schema migrations
+// unless DB_AUTO_MIGRATE is false, for the server and the commands using the store.
+func openDB() (Store, error) {
+	store, err := OpenStore(dbDriver(), dbDSN())
+	if err != nil {
+		return nil, err
 	}
-	return db.Save(out).Error
+	migrator, ok := store.(Migrator)
+	if !ok || os.Getenv("DB_AUTO_MIGRATE") == "false" {
+		return store, nil
+	}
+	if err := migrator.MigrateUp(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("Can't migrate database: %s", err)
+	}
+	return store, nil
+}
+
+// dbDriver returns the database driver set in the env.
+func dbDriver() string {
+	return os.Getenv("DB_DRIVER")
+}
+
+// dbDSN returns the data source name set in the env.
+func dbDSN() string {
+	return os.Getenv("DB_DSN")
+}
+
+// OpenStore opens a store with the driver and the data source name.
+// An empty dsn is replaced by the default one of the driver.
+func OpenStore(driver, dsn string) (Store, error) {
+	switch driver {
+	case "", "mysql":
+		if dsn == "" {
+			dsn = mysqlDSN()
+		}
+		return openSQLStore("mysql", dsn)
+	case "sqlite3":
+		if dsn == "" {
+			dsn = "peppersalt.db"
+		}
+		return openSQLStore("sqlite3", dsn)
+	case "memory":
+		return newMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q", driver)
+}
+
+// mysqlDSN returns the MySQL data source name built from the env.
+func mysqlDSN() string {
+	host := os.Getenv("MYSQL_HOST")
+	if host == "" {
+		host = "db:3306"
+	}
+	user := os.Getenv("MYSQL_USER")
+	password := os.Getenv("MYSQL_PASSWORD")
+	dbname := os.Getenv("MYSQL_DATABASE")
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local", user, password, host, dbname)
 }
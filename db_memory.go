@@ -0,0 +1,900 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// memoryStore is a Store keeping every record in the process memory.
+// It is meant for development and tests.
+type memoryStore struct {
+	mu   *sync.Mutex
+	data *memoryData
+	inTx bool
+}
+
+// memoryData contains the tables of a memoryStore.
+type memoryData struct {
+	lastIDs          map[string]uint
+	achievements     []Achievement
+	answers          []Answer
+	answerEntries    []AnswerEntry
+	images           []Image
+	messages         []Message
+	pointEvents      []PointEvent
+	questions        []Question
+	seasons          []Season
+	seasonStandings  []SeasonStanding
+	teams            []Team
+	users            []User
+	userAchievements []UserAchievement
+}
+
+// newMemoryStore creates an empty memory store.
+func newMemoryStore() *memoryStore {
+	return &memoryStore{mu: &sync.Mutex{}, data: newMemoryData()}
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{lastIDs: make(map[string]uint)}
+}
+
+// clone returns a copy of the tables.
+func (d *memoryData) clone() *memoryData {
+	c := &memoryData{
+		lastIDs:          make(map[string]uint, len(d.lastIDs)),
+		achievements:     append([]Achievement(nil), d.achievements...),
+		answers:          append([]Answer(nil), d.answers...),
+		answerEntries:    append([]AnswerEntry(nil), d.answerEntries...),
+		images:           append([]Image(nil), d.images...),
+		messages:         append([]Message(nil), d.messages...),
+		pointEvents:      append([]PointEvent(nil), d.pointEvents...),
+		questions:        append([]Question(nil), d.questions...),
+		seasons:          append([]Season(nil), d.seasons...),
+		seasonStandings:  append([]SeasonStanding(nil), d.seasonStandings...),
+		teams:            append([]Team(nil), d.teams...),
+		users:            append([]User(nil), d.users...),
+		userAchievements: append([]UserAchievement(nil), d.userAchievements...),
+	}
+	for table, id := range d.lastIDs {
+		c.lastIDs[table] = id
+	}
+	return c
+}
+
+// newModel returns the model of a new record of the table.
+func (d *memoryData) newModel(table string) gorm.Model {
+	d.lastIDs[table]++
+	now := time.Now()
+	return gorm.Model{ID: d.lastIDs[table], CreatedAt: now, UpdatedAt: now}
+}
+
+// inRange reports whether t is from the time until to. A zero to doesn't bound it.
+func inRange(t, from, to time.Time) bool {
+	return !t.Before(from) && (to.IsZero() || t.Before(to))
+}
+
+func (s *memoryStore) lock() {
+	if !s.inTx {
+		s.mu.Lock()
+	}
+}
+
+func (s *memoryStore) unlock() {
+	if !s.inTx {
+		s.mu.Unlock()
+	}
+}
+
+func (s *memoryStore) Transaction(fn func(tx Store) error) error {
+	s.lock()
+	defer s.unlock()
+	snapshot := s.data.clone()
+	if err := fn(&memoryStore{mu: s.mu, data: s.data, inTx: true}); err != nil {
+		*s.data = *snapshot
+		return err
+	}
+	return nil
+}
+
+func (s *memoryStore) Reset() error {
+	s.lock()
+	defer s.unlock()
+	*s.data = *newMemoryData()
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) GetUsers() ([]User, error) {
+	s.lock()
+	defer s.unlock()
+	return append([]User(nil), s.data.users...), nil
+}
+
+func (s *memoryStore) GetUser(id uint) (*User, error) {
+	s.lock()
+	defer s.unlock()
+	for _, user := range s.data.users {
+		if user.ID == id {
+			return &user, nil
+		}
+	}
+	return &User{}, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetUserBySlackID(slackID string) (*User, error) {
+	s.lock()
+	defer s.unlock()
+	if i := s.data.userIndexBySlackID(slackID); i != -1 {
+		user := s.data.users[i]
+		return &user, nil
+	}
+	return &User{}, gorm.ErrRecordNotFound
+}
+
+func (d *memoryData) userIndexBySlackID(slackID string) int {
+	for i, user := range d.users {
+		if user.SlackID == slackID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *memoryStore) GetUsersTop(count int) ([]User, error) {
+	s.lock()
+	defer s.unlock()
+	users := append([]User(nil), s.data.users...)
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].Points > users[j].Points
+	})
+	if count > 0 && len(users) > count {
+		users = users[:count]
+	}
+	return users, nil
+}
+
+func (s *memoryStore) GetUsersTopByCategory(category string, count int) ([]User, error) {
+	s.lock()
+	defer s.unlock()
+	rightAnswers := make(map[uint]uint)
+	for _, question := range s.data.questions {
+		if question.Category != category || question.EndedAt.IsZero() {
+			continue
+		}
+		for _, entry := range s.data.answerEntries {
+			if entry.QuestionID == question.ID && entry.AnswerID == question.RightAnswerID {
+				rightAnswers[entry.UserID]++
+			}
+		}
+	}
+	var users []User
+	for _, user := range s.data.users {
+		if points, ok := rightAnswers[user.ID]; ok {
+			user.Points = points
+			users = append(users, user)
+		}
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].Points > users[j].Points
+	})
+	if count > 0 && len(users) > count {
+		users = users[:count]
+	}
+	return users, nil
+}
+
+func (s *memoryStore) CreateUser(user *User) error {
+	s.lock()
+	defer s.unlock()
+	return s.data.createUser(user)
+}
+
+func (d *memoryData) createUser(user *User) error {
+	if user.SlackID != "" && d.userIndexBySlackID(user.SlackID) != -1 {
+		return fmt.Errorf("duplicate slack_id %q", user.SlackID)
+	}
+	user.Model = d.newModel("users")
+	d.users = append(d.users, *user)
+	return nil
+}
+
+func (s *memoryStore) SaveUserProfile(user *User) error {
+	s.lock()
+	defer s.unlock()
+	i := s.data.userIndexBySlackID(user.SlackID)
+	if i == -1 {
+		return s.data.createUser(user)
+	}
+	stored := &s.data.users[i]
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.ImageURL = user.ImageURL
+	stored.SyncedAt = user.SyncedAt
+	stored.UpdatedAt = time.Now()
+	*user = *stored
+	return nil
+}
+
+func (s *memoryStore) AddPointEvent(event *PointEvent) error {
+	s.lock()
+	defer s.unlock()
+	event.Model = s.data.newModel("point_events")
+	s.data.pointEvents = append(s.data.pointEvents, *event)
+	for i := range s.data.users {
+		if s.data.users[i].ID == event.UserID {
+			s.data.users[i].Points = uint(int(s.data.users[i].Points) + event.Points)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetUserStreak(id uint, streak uint) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.users {
+		if s.data.users[i].ID == id {
+			s.data.users[i].Streak = streak
+			s.data.users[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) SetUserRole(id uint, role string) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.users {
+		if s.data.users[i].ID == id {
+			s.data.users[i].Role = role
+			s.data.users[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) SetUserPoints(id uint, points uint) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.users {
+		if s.data.users[i].ID == id {
+			s.data.users[i].Points = points
+			s.data.users[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) SetUserTeam(id uint, teamID uint) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.users {
+		if s.data.users[i].ID == id {
+			s.data.users[i].TeamID = teamID
+			s.data.users[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetTeams() ([]Team, error) {
+	s.lock()
+	defer s.unlock()
+	teams := append([]Team(nil), s.data.teams...)
+	sort.SliceStable(teams, func(i, j int) bool {
+		return teams[i].Name < teams[j].Name
+	})
+	return teams, nil
+}
+
+func (s *memoryStore) GetTeam(id uint) (*Team, error) {
+	return s.findTeam(func(team *Team) bool { return team.ID == id })
+}
+
+func (s *memoryStore) GetTeamByName(name string) (*Team, error) {
+	return s.findTeam(func(team *Team) bool { return team.Name == name })
+}
+
+func (s *memoryStore) GetTeamBySlackUsergroupID(usergroupID string) (*Team, error) {
+	return s.findTeam(func(team *Team) bool { return team.SlackUsergroupID == usergroupID })
+}
+
+// findTeam returns the first team matching.
+func (s *memoryStore) findTeam(match func(team *Team) bool) (*Team, error) {
+	s.lock()
+	defer s.unlock()
+	for _, team := range s.data.teams {
+		if match(&team) {
+			return &team, nil
+		}
+	}
+	return &Team{}, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) CreateTeam(team *Team) error {
+	s.lock()
+	defer s.unlock()
+	for _, other := range s.data.teams {
+		if other.Name == team.Name {
+			return fmt.Errorf("duplicate name %q", team.Name)
+		}
+	}
+	team.Model = s.data.newModel("teams")
+	s.data.teams = append(s.data.teams, *team)
+	return nil
+}
+
+func (s *memoryStore) SaveTeam(team *Team) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.teams {
+		if s.data.teams[i].ID == team.ID {
+			team.UpdatedAt = time.Now()
+			s.data.teams[i] = *team
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetPointEvents(userID uint, offset, limit int) ([]PointEvent, int, error) {
+	s.lock()
+	defer s.unlock()
+	var events []PointEvent
+	for i := len(s.data.pointEvents) - 1; i >= 0; i-- {
+		if s.data.pointEvents[i].UserID == userID {
+			events = append(events, s.data.pointEvents[i])
+		}
+	}
+	total := len(events)
+	if offset > len(events) {
+		offset = len(events)
+	}
+	events = events[offset:]
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, total, nil
+}
+
+func (s *memoryStore) SumPointEvents(from, to time.Time) (map[uint]int, error) {
+	s.lock()
+	defer s.unlock()
+	totals := make(map[uint]int)
+	for _, event := range s.data.pointEvents {
+		if inRange(event.CreatedAt, from, to) {
+			totals[event.UserID] += event.Points
+		}
+	}
+	return totals, nil
+}
+
+func (s *memoryStore) GetFirstRightAnswers(from, to time.Time) (map[uint]time.Time, error) {
+	s.lock()
+	defer s.unlock()
+	first := make(map[uint]time.Time)
+	for _, event := range s.data.pointEvents {
+		if event.Reason != ReasonRightAnswer || !inRange(event.CreatedAt, from, to) {
+			continue
+		}
+		for _, entry := range s.data.answerEntries {
+			if entry.QuestionID != event.QuestionID || entry.UserID != event.UserID {
+				continue
+			}
+			if t, ok := first[entry.UserID]; !ok || entry.UpdatedAt.Before(t) {
+				first[entry.UserID] = entry.UpdatedAt
+			}
+		}
+	}
+	return first, nil
+}
+
+func (s *memoryStore) CountPointEvents(userID uint, reason string) (int, error) {
+	s.lock()
+	defer s.unlock()
+	count := 0
+	for _, event := range s.data.pointEvents {
+		if event.UserID == userID && event.Reason == reason {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) GetAchievement(id uint) (*Achievement, error) {
+	return s.findAchievement(func(achievement *Achievement) bool { return achievement.ID == id })
+}
+
+func (s *memoryStore) GetAchievementByCode(code string) (*Achievement, error) {
+	return s.findAchievement(func(achievement *Achievement) bool { return achievement.Code == code })
+}
+
+// findAchievement returns the first achievement matching.
+func (s *memoryStore) findAchievement(match func(achievement *Achievement) bool) (*Achievement, error) {
+	s.lock()
+	defer s.unlock()
+	for _, achievement := range s.data.achievements {
+		if match(&achievement) {
+			return &achievement, nil
+		}
+	}
+	return &Achievement{}, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) CreateAchievement(achievement *Achievement) error {
+	s.lock()
+	defer s.unlock()
+	for _, other := range s.data.achievements {
+		if other.Code == achievement.Code {
+			return fmt.Errorf("duplicate code %q", achievement.Code)
+		}
+	}
+	achievement.Model = s.data.newModel("achievements")
+	s.data.achievements = append(s.data.achievements, *achievement)
+	return nil
+}
+
+func (s *memoryStore) SaveAchievement(achievement *Achievement) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.achievements {
+		if s.data.achievements[i].ID == achievement.ID {
+			achievement.UpdatedAt = time.Now()
+			s.data.achievements[i] = *achievement
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetUserAchievements(userID uint) ([]UserAchievement, error) {
+	s.lock()
+	defer s.unlock()
+	var userAchievements []UserAchievement
+	for _, userAchievement := range s.data.userAchievements {
+		if userAchievement.UserID == userID {
+			userAchievements = append(userAchievements, userAchievement)
+		}
+	}
+	return userAchievements, nil
+}
+
+func (s *memoryStore) CreateUserAchievement(userAchievement *UserAchievement) error {
+	s.lock()
+	defer s.unlock()
+	for _, other := range s.data.userAchievements {
+		if other.UserID == userAchievement.UserID && other.AchievementID == userAchievement.AchievementID {
+			return errAchievementUnlocked
+		}
+	}
+	userAchievement.Model = s.data.newModel("user_achievements")
+	s.data.userAchievements = append(s.data.userAchievements, *userAchievement)
+	return nil
+}
+
+func (s *memoryStore) GetQuestion(id uint) (*Question, error) {
+	s.lock()
+	defer s.unlock()
+	for _, question := range s.data.questions {
+		if question.ID == id {
+			return &question, nil
+		}
+	}
+	return &Question{}, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetQuestionBySentence(sentence string) (*Question, error) {
+	s.lock()
+	defer s.unlock()
+	for _, question := range s.data.questions {
+		if question.Sentence == sentence {
+			return &question, nil
+		}
+	}
+	return &Question{}, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetCurrentQuestion() (*Question, error) {
+	s.lock()
+	defer s.unlock()
+	now := time.Now()
+	var current *Question
+	for i, question := range s.data.questions {
+		if question.Status != QuestionApproved || question.StartedAt.After(now) {
+			continue
+		}
+		if current == nil || !question.StartedAt.Before(current.StartedAt) {
+			current = &s.data.questions[i]
+		}
+	}
+	if current == nil {
+		return &Question{}, gorm.ErrRecordNotFound
+	}
+	question := *current
+	return &question, nil
+}
+
+func (s *memoryStore) GetUnstartedQuestions() ([]Question, error) {
+	s.lock()
+	defer s.unlock()
+	var questions []Question
+	for _, question := range s.data.questions {
+		if question.Status == QuestionApproved && question.StartedAt.IsZero() {
+			questions = append(questions, question)
+		}
+	}
+	return questions, nil
+}
+
+func (s *memoryStore) GetQuestionsByStatus(status string) ([]Question, error) {
+	s.lock()
+	defer s.unlock()
+	var questions []Question
+	for _, question := range s.data.questions {
+		if question.Status == status {
+			questions = append(questions, question)
+		}
+	}
+	return questions, nil
+}
+
+func (s *memoryStore) GetQuestions(filter QuestionFilter) ([]Question, int, error) {
+	s.lock()
+	defer s.unlock()
+	now := time.Now()
+	var questions []Question
+	for i := range s.data.questions {
+		if filter.match(&s.data.questions[i], now) {
+			questions = append(questions, s.data.questions[i])
+		}
+	}
+	sort.SliceStable(questions, func(i, j int) bool {
+		if filter.State == QuestionUpcoming {
+			return questions[i].ID < questions[j].ID
+		}
+		if !questions[i].StartedAt.Equal(questions[j].StartedAt) {
+			return questions[i].StartedAt.After(questions[j].StartedAt)
+		}
+		return questions[i].ID > questions[j].ID
+	})
+	total := len(questions)
+	if filter.Offset >= total {
+		return nil, total, nil
+	}
+	questions = questions[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(questions) {
+		questions = questions[:filter.Limit]
+	}
+	return questions, total, nil
+}
+
+func (s *memoryStore) CreateQuestion(question *Question) error {
+	s.lock()
+	defer s.unlock()
+	question.Model = s.data.newModel("questions")
+	s.data.questions = append(s.data.questions, *question)
+	return nil
+}
+
+func (s *memoryStore) SaveQuestion(question *Question) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.questions {
+		if s.data.questions[i].ID == question.ID {
+			question.UpdatedAt = time.Now()
+			s.data.questions[i] = *question
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) CountStumpers(userID uint) (int, error) {
+	s.lock()
+	defer s.unlock()
+	count := 0
+	for _, question := range s.data.questions {
+		if question.UserID != userID || question.EndedAt.IsZero() || question.Skipped {
+			continue
+		}
+		answered, right := false, false
+		for _, entry := range s.data.answerEntries {
+			if entry.QuestionID == question.ID {
+				answered = true
+				right = right || entry.AnswerID == question.RightAnswerID
+			}
+		}
+		if answered && !right {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) GetAnswersByQuestionID(questionID uint) ([]Answer, error) {
+	s.lock()
+	defer s.unlock()
+	var answers []Answer
+	for _, answer := range s.data.answers {
+		if answer.QuestionID == questionID {
+			answers = append(answers, answer)
+		}
+	}
+	return answers, nil
+}
+
+func (s *memoryStore) CreateAnswer(answer *Answer) error {
+	s.lock()
+	defer s.unlock()
+	answer.Model = s.data.newModel("answers")
+	s.data.answers = append(s.data.answers, *answer)
+	return nil
+}
+
+func (s *memoryStore) SaveAnswerEntry(entry *AnswerEntry) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.answerEntries {
+		stored := &s.data.answerEntries[i]
+		if stored.UserID == entry.UserID && stored.QuestionID == entry.QuestionID {
+			stored.AnswerID = entry.AnswerID
+			stored.UpdatedAt = time.Now()
+			*entry = *stored
+			return nil
+		}
+	}
+	entry.Model = s.data.newModel("answer_entries")
+	s.data.answerEntries = append(s.data.answerEntries, *entry)
+	return nil
+}
+
+func (s *memoryStore) CountAnswerEntries(questionID uint) (int, error) {
+	s.lock()
+	defer s.unlock()
+	count := 0
+	for _, entry := range s.data.answerEntries {
+		if entry.QuestionID == questionID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) CountAnswerEntriesByUserID(userID uint) (int, error) {
+	s.lock()
+	defer s.unlock()
+	count := 0
+	for _, entry := range s.data.answerEntries {
+		if entry.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) GetAnswerEntriesByQuestionID(questionID uint) ([]AnswerEntry, error) {
+	s.lock()
+	defer s.unlock()
+	var entries []AnswerEntry
+	for _, entry := range s.data.answerEntries {
+		if entry.QuestionID == questionID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) GetMessages(fromID uint, count int) ([]Message, error) {
+	s.lock()
+	defer s.unlock()
+	var messages []Message
+	for i := len(s.data.messages) - 1; i >= 0; i-- {
+		if count > 0 && len(messages) == count {
+			break
+		}
+		if message := s.data.messages[i]; message.ID > fromID {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (s *memoryStore) GetMessageBySlackTS(channel, ts string) (*Message, error) {
+	s.lock()
+	defer s.unlock()
+	for _, message := range s.data.messages {
+		if message.Channel == channel && message.SlackTS == ts {
+			return &message, nil
+		}
+	}
+	return &Message{}, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) CountMessagesByUserID(userID uint) (int, error) {
+	s.lock()
+	defer s.unlock()
+	count := 0
+	for _, message := range s.data.messages {
+		if message.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) CreateMessage(message *Message) error {
+	s.lock()
+	defer s.unlock()
+	message.Model = s.data.newModel("messages")
+	s.data.messages = append(s.data.messages, *message)
+	return nil
+}
+
+func (s *memoryStore) SaveMessage(message *Message) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.messages {
+		if s.data.messages[i].ID == message.ID {
+			message.UpdatedAt = time.Now()
+			s.data.messages[i] = *message
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) DeleteMessage(id uint) error {
+	s.lock()
+	defer s.unlock()
+	for i, message := range s.data.messages {
+		if message.ID == id {
+			s.data.messages = append(s.data.messages[:i], s.data.messages[i+1:]...)
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetSeason(id uint) (*Season, error) {
+	s.lock()
+	defer s.unlock()
+	for _, season := range s.data.seasons {
+		if season.ID == id {
+			return &season, nil
+		}
+	}
+	return &Season{}, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetCurrentSeason() (*Season, error) {
+	s.lock()
+	defer s.unlock()
+	var current *Season
+	for i, season := range s.data.seasons {
+		if season.EndedAt.IsZero() && (current == nil || !season.StartedAt.Before(current.StartedAt)) {
+			current = &s.data.seasons[i]
+		}
+	}
+	if current == nil {
+		return &Season{}, gorm.ErrRecordNotFound
+	}
+	season := *current
+	return &season, nil
+}
+
+func (s *memoryStore) GetPastSeasons() ([]Season, error) {
+	s.lock()
+	defer s.unlock()
+	var seasons []Season
+	for _, season := range s.data.seasons {
+		if !season.EndedAt.IsZero() {
+			seasons = append(seasons, season)
+		}
+	}
+	sort.SliceStable(seasons, func(i, j int) bool {
+		if !seasons[i].StartedAt.Equal(seasons[j].StartedAt) {
+			return seasons[i].StartedAt.After(seasons[j].StartedAt)
+		}
+		return seasons[i].ID > seasons[j].ID
+	})
+	return seasons, nil
+}
+
+func (s *memoryStore) CreateSeason(season *Season) error {
+	s.lock()
+	defer s.unlock()
+	season.Model = s.data.newModel("seasons")
+	s.data.seasons = append(s.data.seasons, *season)
+	return nil
+}
+
+func (s *memoryStore) SaveSeason(season *Season) error {
+	s.lock()
+	defer s.unlock()
+	for i := range s.data.seasons {
+		if s.data.seasons[i].ID == season.ID {
+			season.UpdatedAt = time.Now()
+			s.data.seasons[i] = *season
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetSeasonStandings(seasonID uint, count int) ([]SeasonStanding, error) {
+	s.lock()
+	defer s.unlock()
+	var standings []SeasonStanding
+	for _, standing := range s.data.seasonStandings {
+		if standing.SeasonID == seasonID {
+			standings = append(standings, standing)
+		}
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Position < standings[j].Position
+	})
+	if count > 0 && len(standings) > count {
+		standings = standings[:count]
+	}
+	return standings, nil
+}
+
+func (s *memoryStore) CreateSeasonStanding(standing *SeasonStanding) error {
+	s.lock()
+	defer s.unlock()
+	standing.Model = s.data.newModel("season_standings")
+	s.data.seasonStandings = append(s.data.seasonStandings, *standing)
+	return nil
+}
+
+func (s *memoryStore) GetImage(id uint) (*Image, error) {
+	s.lock()
+	defer s.unlock()
+	for _, img := range s.data.images {
+		if img.ID == id {
+			return &img, nil
+		}
+	}
+	return &Image{}, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetLastImage() (*Image, error) {
+	s.lock()
+	defer s.unlock()
+	if len(s.data.images) == 0 {
+		return &Image{}, gorm.ErrRecordNotFound
+	}
+	img := s.data.images[len(s.data.images)-1]
+	return &img, nil
+}
+
+func (s *memoryStore) CreateImage(image *Image) error {
+	s.lock()
+	defer s.unlock()
+	image.Model = s.data.newModel("images")
+	s.data.images = append(s.data.images, *image)
+	return nil
+}
+
+func (s *memoryStore) DeleteImage(id uint) error {
+	s.lock()
+	defer s.unlock()
+	for i, image := range s.data.images {
+		if image.ID == id {
+			s.data.images = append(s.data.images[:i], s.data.images[i+1:]...)
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// models lists every table of the database.
+var models = []interface{}{&Achievement{}, &Answer{}, &AnswerEntry{}, &Image{}, &Message{}, &PointEvent{}, &Question{}, &Season{}, &SeasonStanding{}, &Team{}, &User{}, &UserAchievement{}}
+
+// sqlStore is a Store backed by a SQL database through gorm.
+type sqlStore struct {
+	db   *gorm.DB
+	inTx bool
+}
+
+// openSQLStore opens the SQL database.
+// The schema is handled by the migrations, see Migrator.
+func openSQLStore(dialect, dsn string) (*sqlStore, error) {
+	conn, err := gorm.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == "sqlite3" {
+		// SQLite only supports one writer, and each connection to
+		// ":memory:" would otherwise open its own database.
+		conn.DB().SetMaxOpenConns(1)
+	}
+	return &sqlStore{db: conn}, nil
+}
+
+func (s *sqlStore) Transaction(fn func(tx Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(&sqlStore{db: tx, inTx: true}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *sqlStore) Reset() error {
+	if err := s.db.DropTableIfExists(append(models, &SchemaMigration{})...).Error; err != nil {
+		return err
+	}
+	return s.MigrateUp()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) GetUsers() (users []User, err error) {
+	err = s.db.Order("id").Find(&users).Error
+	return
+}
+
+func (s *sqlStore) GetUser(id uint) (*User, error) {
+	user := &User{}
+	err := s.db.First(user, id).Error
+	return user, err
+}
+
+func (s *sqlStore) GetUserBySlackID(slackID string) (*User, error) {
+	user := &User{}
+	err := s.db.Where(&User{SlackID: slackID}).First(user).Error
+	return user, err
+}
+
+func (s *sqlStore) GetUsersTop(count int) (users []User, err error) {
+	err = s.db.Order("points desc").Limit(count).Find(&users).Error
+	return
+}
+
+func (s *sqlStore) GetUsersTopByCategory(category string, count int) ([]User, error) {
+	query := s.db.Table("answer_entries").
+		Select("answer_entries.user_id, COUNT(*) AS points").
+		Joins("JOIN questions ON questions.id = answer_entries.question_id AND questions.right_answer_id = answer_entries.answer_id").
+		Where("questions.category = ? AND questions.ended_at > ?", category, time.Time{}).
+		Where("answer_entries.deleted_at IS NULL AND questions.deleted_at IS NULL").
+		Group("answer_entries.user_id").
+		Order("points desc, answer_entries.user_id")
+	if count > 0 {
+		query = query.Limit(count)
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Points); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// The users are loaded once the rows are closed, to not hold two connections.
+	for i := range users {
+		points := users[i].Points
+		if err := s.db.First(&users[i], users[i].ID).Error; err != nil {
+			return nil, err
+		}
+		users[i].Points = points
+	}
+	return users, nil
+}
+
+func (s *sqlStore) CreateUser(user *User) error {
+	return s.db.Create(user).Error
+}
+
+func (s *sqlStore) SaveUserProfile(user *User) error {
+	stored := &User{}
+	if s.db.Where(&User{SlackID: user.SlackID}).First(stored).RecordNotFound() {
+		return s.db.Create(user).Error
+	}
+	// Only the profile is updated, not to overwrite the points given meanwhile.
+	err := s.db.Model(stored).Updates(map[string]interface{}{
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"image_url":  user.ImageURL,
+		"synced_at":  user.SyncedAt,
+	}).Error
+	if err != nil {
+		return err
+	}
+	*user = *stored
+	return nil
+}
+
+func (s *sqlStore) AddPointEvent(event *PointEvent) error {
+	if err := s.db.Create(event).Error; err != nil {
+		return err
+	}
+	return s.db.Exec("UPDATE users SET points = points + ? WHERE id = ?", event.Points, event.UserID).Error
+}
+
+func (s *sqlStore) SetUserStreak(id uint, streak uint) error {
+	return s.db.Model(&User{Model: gorm.Model{ID: id}}).Update("streak", streak).Error
+}
+
+func (s *sqlStore) SetUserPoints(id uint, points uint) error {
+	return s.db.Model(&User{Model: gorm.Model{ID: id}}).Update("points", points).Error
+}
+
+func (s *sqlStore) SetUserRole(id uint, role string) error {
+	return s.db.Model(&User{Model: gorm.Model{ID: id}}).Update("role", role).Error
+}
+
+func (s *sqlStore) SetUserTeam(id uint, teamID uint) error {
+	return s.db.Model(&User{Model: gorm.Model{ID: id}}).Update("team_id", teamID).Error
+}
+
+func (s *sqlStore) GetTeams() (teams []Team, err error) {
+	err = s.db.Order("name").Find(&teams).Error
+	return
+}
+
+func (s *sqlStore) GetTeam(id uint) (*Team, error) {
+	team := &Team{}
+	err := s.db.First(team, id).Error
+	return team, err
+}
+
+func (s *sqlStore) GetTeamByName(name string) (*Team, error) {
+	team := &Team{}
+	err := s.db.Where("name = ?", name).First(team).Error
+	return team, err
+}
+
+func (s *sqlStore) GetTeamBySlackUsergroupID(usergroupID string) (*Team, error) {
+	team := &Team{}
+	err := s.db.Where("slack_usergroup_id = ?", usergroupID).First(team).Error
+	return team, err
+}
+
+func (s *sqlStore) CreateTeam(team *Team) error {
+	return s.db.Create(team).Error
+}
+
+func (s *sqlStore) SaveTeam(team *Team) error {
+	return s.db.Save(team).Error
+}
+
+func (s *sqlStore) GetPointEvents(userID uint, offset, limit int) (events []PointEvent, total int, err error) {
+	query := s.db.Model(&PointEvent{}).Where("user_id = ?", userID)
+	if err = query.Count(&total).Error; err != nil {
+		return
+	}
+	err = query.Order("id desc").Offset(offset).Limit(limit).Find(&events).Error
+	return
+}
+
+func (s *sqlStore) SumPointEvents(from, to time.Time) (map[uint]int, error) {
+	query := s.db.Model(&PointEvent{}).Select("user_id, SUM(points)").Where("created_at >= ?", from)
+	if !to.IsZero() {
+		query = query.Where("created_at < ?", to)
+	}
+	rows, err := query.Group("user_id").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	totals := make(map[uint]int)
+	for rows.Next() {
+		var userID uint
+		var points int
+		if err := rows.Scan(&userID, &points); err != nil {
+			return nil, err
+		}
+		totals[userID] = points
+	}
+	return totals, rows.Err()
+}
+
+func (s *sqlStore) GetFirstRightAnswers(from, to time.Time) (map[uint]time.Time, error) {
+	query := s.db.Table("point_events").
+		Select("point_events.user_id, answer_entries.updated_at").
+		Joins("JOIN answer_entries ON answer_entries.question_id = point_events.question_id AND answer_entries.user_id = point_events.user_id").
+		Where("point_events.reason = ? AND point_events.created_at >= ?", ReasonRightAnswer, from).
+		Where("point_events.deleted_at IS NULL AND answer_entries.deleted_at IS NULL")
+	if !to.IsZero() {
+		query = query.Where("point_events.created_at < ?", to)
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	first := make(map[uint]time.Time)
+	for rows.Next() {
+		var userID uint
+		var answeredAt time.Time
+		if err := rows.Scan(&userID, &answeredAt); err != nil {
+			return nil, err
+		}
+		if t, ok := first[userID]; !ok || answeredAt.Before(t) {
+			first[userID] = answeredAt
+		}
+	}
+	return first, rows.Err()
+}
+
+func (s *sqlStore) CountPointEvents(userID uint, reason string) (count int, err error) {
+	err = s.db.Model(&PointEvent{}).Where("user_id = ? AND reason = ?", userID, reason).Count(&count).Error
+	return
+}
+
+func (s *sqlStore) GetAchievement(id uint) (*Achievement, error) {
+	achievement := &Achievement{}
+	err := s.db.First(achievement, id).Error
+	return achievement, err
+}
+
+func (s *sqlStore) GetAchievementByCode(code string) (*Achievement, error) {
+	achievement := &Achievement{}
+	err := s.db.Where("code = ?", code).First(achievement).Error
+	return achievement, err
+}
+
+func (s *sqlStore) CreateAchievement(achievement *Achievement) error {
+	return s.db.Create(achievement).Error
+}
+
+func (s *sqlStore) SaveAchievement(achievement *Achievement) error {
+	return s.db.Save(achievement).Error
+}
+
+func (s *sqlStore) GetUserAchievements(userID uint) (userAchievements []UserAchievement, err error) {
+	err = s.db.Where("user_id = ?", userID).Order("id").Find(&userAchievements).Error
+	return
+}
+
+func (s *sqlStore) CreateUserAchievement(userAchievement *UserAchievement) error {
+	err := s.db.Create(userAchievement).Error
+	if err != nil && !s.db.Where("user_id = ? AND achievement_id = ?", userAchievement.UserID, userAchievement.AchievementID).First(&UserAchievement{}).RecordNotFound() {
+		return errAchievementUnlocked
+	}
+	return err
+}
+
+func (s *sqlStore) GetQuestion(id uint) (*Question, error) {
+	question := &Question{}
+	err := s.db.First(question, id).Error
+	return question, err
+}
+
+func (s *sqlStore) GetQuestionBySentence(sentence string) (*Question, error) {
+	question := &Question{}
+	err := s.db.Where("sentence = ?", sentence).First(question).Error
+	return question, err
+}
+
+func (s *sqlStore) GetCurrentQuestion() (*Question, error) {
+	question := &Question{}
+	err := s.db.Where("status = ? AND started_at <= ?", QuestionApproved, time.Now()).Order("started_at desc, id desc").First(question).Error
+	return question, err
+}
+
+func (s *sqlStore) GetUnstartedQuestions() (questions []Question, err error) {
+	err = s.db.Where("status = ? AND started_at = ?", QuestionApproved, time.Time{}).Find(&questions).Error
+	return
+}
+
+func (s *sqlStore) GetQuestionsByStatus(status string) (questions []Question, err error) {
+	err = s.db.Where("status = ?", status).Order("id").Find(&questions).Error
+	return
+}
+
+func (s *sqlStore) GetQuestions(filter QuestionFilter) (questions []Question, total int, err error) {
+	now := time.Now()
+	query := s.db.Model(&Question{}).Where("status = ?", QuestionApproved)
+	order := "started_at desc, id desc"
+	switch filter.State {
+	case QuestionUpcoming:
+		query = query.Where("(ended_at IS NULL OR ended_at = ?) AND (started_at = ? OR started_at > ?)", time.Time{}, time.Time{}, now)
+		order = "id"
+	case QuestionCurrent:
+		query = query.Where("(ended_at IS NULL OR ended_at = ?) AND started_at > ? AND started_at <= ?", time.Time{}, time.Time{}, now)
+	case QuestionPast:
+		query = query.Where("ended_at > ?", time.Time{})
+	}
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Category != "" {
+		query = query.Where("category = ?", filter.Category)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("started_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("started_at < ?", filter.To)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return
+	}
+	query = query.Order(order).Offset(filter.Offset)
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	err = query.Find(&questions).Error
+	return
+}
+
+func (s *sqlStore) CreateQuestion(question *Question) error {
+	return s.db.Create(question).Error
+}
+
+func (s *sqlStore) SaveQuestion(question *Question) error {
+	return s.db.Save(question).Error
+}
+
+func (s *sqlStore) CountStumpers(userID uint) (count int, err error) {
+	err = s.db.Model(&Question{}).
+		Where("user_id = ? AND ended_at > ? AND skipped = ?", userID, time.Time{}, false).
+		Where("EXISTS (SELECT 1 FROM answer_entries WHERE answer_entries.question_id = questions.id AND answer_entries.deleted_at IS NULL)").
+		Where("NOT EXISTS (SELECT 1 FROM answer_entries WHERE answer_entries.question_id = questions.id AND answer_entries.answer_id = questions.right_answer_id AND answer_entries.deleted_at IS NULL)").
+		Count(&count).Error
+	return
+}
+
+func (s *sqlStore) GetAnswersByQuestionID(questionID uint) (answers []Answer, err error) {
+	err = s.db.Where(&Answer{QuestionID: questionID}).Order("id").Find(&answers).Error
+	return
+}
+
+func (s *sqlStore) CreateAnswer(answer *Answer) error {
+	return s.db.Create(answer).Error
+}
+
+func (s *sqlStore) SaveAnswerEntry(entry *AnswerEntry) error {
+	stored := &AnswerEntry{}
+	if s.db.Where(&AnswerEntry{UserID: entry.UserID, QuestionID: entry.QuestionID}).First(stored).RecordNotFound() {
+		return s.db.Create(entry).Error
+	}
+	stored.AnswerID = entry.AnswerID
+	if err := s.db.Save(stored).Error; err != nil {
+		return err
+	}
+	*entry = *stored
+	return nil
+}
+
+func (s *sqlStore) CountAnswerEntries(questionID uint) (count int, err error) {
+	err = s.db.Model(&AnswerEntry{}).Where(&AnswerEntry{QuestionID: questionID}).Count(&count).Error
+	return
+}
+
+func (s *sqlStore) CountAnswerEntriesByUserID(userID uint) (count int, err error) {
+	err = s.db.Model(&AnswerEntry{}).Where(&AnswerEntry{UserID: userID}).Count(&count).Error
+	return
+}
+
+func (s *sqlStore) GetAnswerEntriesByQuestionID(questionID uint) (entries []AnswerEntry, err error) {
+	err = s.db.Where(&AnswerEntry{QuestionID: questionID}).Order("id").Find(&entries).Error
+	return
+}
+
+func (s *sqlStore) GetMessages(fromID uint, count int) (messages []Message, err error) {
+	err = s.db.Order("id desc").Limit(count).Find(&messages, "id > ?", fromID).Error
+	return
+}
+
+func (s *sqlStore) GetMessageBySlackTS(channel, ts string) (*Message, error) {
+	message := &Message{}
+	err := s.db.Where("channel = ? AND slack_ts = ?", channel, ts).First(message).Error
+	return message, err
+}
+
+func (s *sqlStore) CountMessagesByUserID(userID uint) (count int, err error) {
+	err = s.db.Model(&Message{}).Where(&Message{UserID: userID}).Count(&count).Error
+	return
+}
+
+func (s *sqlStore) CreateMessage(message *Message) error {
+	return s.db.Create(message).Error
+}
+
+func (s *sqlStore) SaveMessage(message *Message) error {
+	return s.db.Save(message).Error
+}
+
+func (s *sqlStore) DeleteMessage(id uint) error {
+	if id == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	deleted := s.db.Delete(&Message{Model: gorm.Model{ID: id}})
+	if deleted.Error == nil && deleted.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return deleted.Error
+}
+
+func (s *sqlStore) GetSeason(id uint) (*Season, error) {
+	season := &Season{}
+	err := s.db.First(season, id).Error
+	return season, err
+}
+
+func (s *sqlStore) GetCurrentSeason() (*Season, error) {
+	season := &Season{}
+	err := s.db.Where("ended_at <= ?", time.Time{}).Order("started_at desc, id desc").First(season).Error
+	return season, err
+}
+
+func (s *sqlStore) GetPastSeasons() (seasons []Season, err error) {
+	err = s.db.Where("ended_at > ?", time.Time{}).Order("started_at desc, id desc").Find(&seasons).Error
+	return
+}
+
+func (s *sqlStore) CreateSeason(season *Season) error {
+	return s.db.Create(season).Error
+}
+
+func (s *sqlStore) SaveSeason(season *Season) error {
+	return s.db.Save(season).Error
+}
+
+func (s *sqlStore) GetSeasonStandings(seasonID uint, count int) (standings []SeasonStanding, err error) {
+	query := s.db.Where("season_id = ?", seasonID).Order("position")
+	if count > 0 {
+		query = query.Limit(count)
+	}
+	err = query.Find(&standings).Error
+	return
+}
+
+func (s *sqlStore) CreateSeasonStanding(standing *SeasonStanding) error {
+	return s.db.Create(standing).Error
+}
+
+func (s *sqlStore) GetImage(id uint) (*Image, error) {
+	img := &Image{}
+	err := s.db.First(img, id).Error
+	return img, err
+}
+
+func (s *sqlStore) GetLastImage() (*Image, error) {
+	img := &Image{}
+	err := s.db.Last(img).Error
+	return img, err
+}
+
+func (s *sqlStore) CreateImage(image *Image) error {
+	return s.db.Create(image).Error
+}
+
+func (s *sqlStore) DeleteImage(id uint) error {
+	if id == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	deleted := s.db.Delete(&Image{Model: gorm.Model{ID: id}})
+	if deleted.Error == nil && deleted.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return deleted.Error
+}
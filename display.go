@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DisplayStateRequest contains the data of get display state request.
+type DisplayStateRequest struct {
+	Since uint64 `schema:"since"`
+}
+
+// DisplaySection is a part of the display state.
+// Version is the id of the last event which changed it.
+type DisplaySection struct {
+	Version uint64
+	Data    interface{}
+}
+
+// DisplayState contains everything a TV renders.
+// The sections unchanged since the version asked are omitted.
+type DisplayState struct {
+	Version     uint64
+	Images      *DisplaySection `json:",omitempty"`
+	Messages    *DisplaySection `json:",omitempty"`
+	Question    *DisplaySection `json:",omitempty"`
+	Leaderboard *DisplaySection `json:",omitempty"`
+	Rotation    *DisplaySection `json:",omitempty"`
+}
+
+// getDisplayState returns the latest image, the last messages, the current question,
+// the users top and the rotation state, read at once.
+func getDisplayState(w http.ResponseWriter, r *http.Request) {
+	var req DisplayStateRequest
+	if err := decodeRequestQuery(r, &req); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	state, err := GetDisplayState(req.Since)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	etag := fmt.Sprintf(`"%d"`, state.Version)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	renderJSON(w, http.StatusOK, state)
+}
+
+// GetDisplayState returns the sections changed after the version since.
+// The versions are read before the data, so that the data is never older than its version.
+func GetDisplayState(since uint64) (*DisplayState, error) {
+	state := &DisplayState{}
+	sections := map[string]**DisplaySection{
+		TopicImages:      &state.Images,
+		TopicMessages:    &state.Messages,
+		TopicQuestion:    &state.Question,
+		TopicLeaderboard: &state.Leaderboard,
+		TopicRotation:    &state.Rotation,
+	}
+	for topic, section := range sections {
+		version := events.Version(topicEventTypes(topic)...)
+		if version > state.Version {
+			state.Version = version
+		}
+		if version > since {
+			*section = &DisplaySection{Version: version}
+		}
+	}
+	err := db.Transaction(func(tx Store) error {
+		var err error
+		if state.Images != nil {
+			if state.Images.Data, err = getDisplayImage(tx); err != nil {
+				return err
+			}
+		}
+		if state.Messages != nil {
+			if state.Messages.Data, err = tx.GetMessages(0, 10); err != nil {
+				return err
+			}
+		}
+		if state.Question != nil {
+			if state.Question.Data, err = getDisplayQuestion(tx); err != nil {
+				return err
+			}
+		}
+		if state.Leaderboard != nil {
+			if state.Leaderboard.Data, err = GetSeasonLeaderboard(tx, leaderboardSize); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if state.Rotation != nil {
+		state.Rotation.Data = scheduler.State()
+	}
+	return state, nil
+}
+
+// getDisplayImage returns the last image, or nil if there is none.
+func getDisplayImage(tx Store) (*Image, error) {
+	image, err := tx.GetLastImage()
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return image, err
+}
+
+// getDisplayQuestion returns the current question, or nil if there is none.
+func getDisplayQuestion(tx Store) (*GetCurrentQuestionAnswer, error) {
+	question, err := tx.GetCurrentQuestion()
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return newCurrentQuestionAnswer(tx, question)
+}
+
+// topicEventTypes returns the types of the events of the topic.
+func topicEventTypes(topic string) []string {
+	var eventTypes []string
+	for eventType, eventTopic := range eventTopics {
+		if eventTopic == topic {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	return eventTypes
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Event types pushed to the TV clients.
+const (
+	EventMessageCreated     = "message.created"
+	EventMessageUpdated     = "message.updated"
+	EventMessageDeleted     = "message.deleted"
+	EventImageCreated       = "image.created"
+	EventImageDeleted       = "image.deleted"
+	EventQuestionRotated    = "question.rotated"
+	EventQuestionRevealed   = "question.revealed"
+	EventLeaderboardChanged = "leaderboard.changed"
+	EventRotationPaused     = "rotation.paused"
+	EventRotationResumed    = "rotation.resumed"
+	// EventReset tells the client that events were missed and it must reload everything.
+	EventReset = "reset"
+)
+
+var (
+	// events is the broker of the events, sized by loadConfig.
+	events = newEventBroker(256)
+
+	eventsHeartbeat = 15 * time.Second
+)
+
+// Event is a change pushed to the TV clients.
+type Event struct {
+	ID   uint64
+	Type string
+	Data interface{}
+}
+
+// eventBroker dispatches the events to the subscribers.
+// The last events are kept in a ring buffer so that the clients can resume.
+type eventBroker struct {
+	mu          sync.Mutex
+	startID     uint64
+	lastID      uint64
+	lastIDs     map[string]uint64
+	buffer      []Event
+	next        int
+	subscribers map[chan Event]struct{}
+}
+
+// newEventBroker creates a broker keeping the last size events.
+// The ids start from the current time, so that they keep increasing
+// when the server restarts.
+func newEventBroker(size int) *eventBroker {
+	startID := uint64(time.Now().UnixNano() / 1000)
+	return &eventBroker{
+		startID:     startID,
+		lastID:      startID,
+		lastIDs:     make(map[string]uint64),
+		buffer:      make([]Event, 0, size),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish sends an event to every subscriber.
+// A subscriber too slow to receive it is unsubscribed.
+func (b *eventBroker) Publish(eventType string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, Data: data}
+	b.lastIDs[eventType] = b.lastID
+	if len(b.buffer) < cap(b.buffer) {
+		b.buffer = append(b.buffer, event)
+	} else if len(b.buffer) > 0 {
+		b.buffer[b.next] = event
+		b.next = (b.next + 1) % len(b.buffer)
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Version returns the id of the last event of one of the types,
+// or the id the broker started from if there was none.
+func (b *eventBroker) Version(eventTypes ...string) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	version := b.startID
+	for _, eventType := range eventTypes {
+		if id := b.lastIDs[eventType]; id > version {
+			version = id
+		}
+	}
+	return version
+}
+
+// Subscribe returns a channel receiving the next events, and the events
+// published after lastID. missed is true if some of them were dropped from the buffer.
+// lastID 0 subscribes to the next events only.
+func (b *eventBroker) Subscribe(lastID uint64) (ch chan Event, replay []Event, missed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch = make(chan Event, 64)
+	b.subscribers[ch] = struct{}{}
+	if lastID == 0 || lastID >= b.lastID {
+		return ch, nil, false
+	}
+	for i := range b.buffer {
+		event := b.buffer[(b.next+i)%len(b.buffer)]
+		if event.ID > lastID {
+			replay = append(replay, event)
+		}
+	}
+	missed = len(replay) == 0 || replay[0].ID != lastID+1
+	return ch, replay, missed
+}
+
+// Unsubscribe stops sending the events to ch.
+func (b *eventBroker) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// getEvents streams the events with Server-Sent Events.
+// The events following the Last-Event-ID header are sent first.
+func getEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		renderJSON(w, http.StatusInternalServerError, Error{"Streaming not supported"})
+		return
+	}
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	ch, replay, missed := events.Subscribe(lastID)
+	defer events.Unsubscribe(ch)
+
+	w.Header().Set(ContentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if missed {
+		writeEvent(w, Event{ID: lastID, Type: EventReset})
+	}
+	for _, event := range replay {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				// Too slow, the client resumes from its last event.
+				return
+			}
+			writeEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes the event in the text/event-stream format.
+func writeEvent(w http.ResponseWriter, event Event) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"type": event.Type,
+			"err":  err,
+		}).Error("Can't render event to JSON")
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
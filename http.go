@@ -24,6 +24,7 @@ var (
 	errInvalidTimestamp    = Error{"Invalid timestamp"}
 	errInvalidToken        = Error{"Invalid token"}
 	errInvalidUserID       = Error{"Invalid user_id"}
+	errInvalidQuestionID   = Error{"Invalid question_id"}
 	errMessagesNotFound    = Error{"Messages not found"}
 	errUserNotFound        = Error{"User not found"}
 	errCurQuestionNotFound = Error{"Current question not found"}
@@ -38,10 +39,11 @@ type Error struct {
 // NewWebService creates a new web service ready to run.
 func NewWebService() *martini.Martini {
 	m := martini.New()
-	m.Handlers(loggerMiddleware(), martini.Recovery(), gzip.All())
+	m.Handlers(loggerMiddleware(), martini.Recovery(), gzipMiddleware("/events", "/ws"))
 	r := newRouter()
 	m.MapTo(r, (*martini.Routes)(nil))
 	m.Action(r.Handle)
+	go screens.Run()
 	return m
 }
 
@@ -50,11 +52,35 @@ func newRouter() martini.Router {
 	r := martini.NewRouter()
 	r.Get("/images/latest", getLastImage)
 	r.Get("/users/top", getUsersTop)
+	r.Get("/teams/top", getTeamsTop)
 	r.Get("/users/:user_id", getUser)
-	r.Post("/messages/slack", addMessage)
+	r.Get("/users/:user_id/points/history", getPointsHistory)
+	r.Get("/users/:user_id/achievements", getUserAchievements)
+	r.Post("/messages/slack", verifySlackRequest(slackOutgoingToken), addMessage)
 	r.Get("/messages", getMessages)
 	r.Get("/questions/current", getCurrentQuestion)
-	r.Post("/slack/commands/tv", slackCommandTV)
+	r.Get("/questions/rotation", getRotationState)
+	r.Get("/questions", getQuestions)
+	r.Get("/questions/:question_id", getQuestion)
+	r.Get("/questions/:question_id/results", getQuestionResults)
+	r.Get("/seasons", getSeasons)
+	r.Get("/seasons/current", getCurrentSeason)
+	r.Get("/seasons/:season_id", getSeason)
+	r.Get("/display/state", getDisplayState)
+	r.Get("/events", getEvents)
+	r.Get("/ws", getWebSocket)
+	r.Get("/screens", getScreens)
+	r.Post("/slack/commands/tv", verifySlackRequest(slackCommandToken), slackCommandTV)
+	r.Post("/slack/events", verifySlackRequest(slackCommandToken), slackEvents)
+	r.Post("/slack/interactions", verifySlackRequest(slackCommandToken), slackInteractions)
+	r.Post("/admin/questions/next", verifyAdminToken, rotateQuestionNow)
+	r.Post("/admin/questions/skip", verifyAdminToken, skipQuestion)
+	r.Post("/admin/questions/:question_id/schedule", verifyAdminToken, scheduleQuestion)
+	r.Post("/admin/questions/import", verifyAdminToken, importQuestionBank)
+	r.Get("/admin/questions/export", verifyAdminToken, exportQuestionBank)
+	r.Post("/admin/users/:user_id/points", verifyAdminToken, adjustUserPoints)
+	r.Post("/admin/points/recompute", verifyAdminToken, recomputeUserPoints)
+	r.Post("/admin/seasons/rollover", verifyAdminToken, rolloverSeasonNow)
 	return r
 }
 
@@ -87,6 +113,22 @@ func renderJSON(w http.ResponseWriter, status int, v interface{}) {
 	}
 }
 
+// gzipMiddleware compresses the responses, except on the streaming paths
+// which must be flushed as they're written.
+func gzipMiddleware(streamingPaths ...string) martini.Handler {
+	handler := gzip.All()
+	return func(req *http.Request, c martini.Context) {
+		for _, path := range streamingPaths {
+			if req.URL.Path == path {
+				return
+			}
+		}
+		if _, err := c.Invoke(handler); err != nil {
+			panic(err)
+		}
+	}
+}
+
 // loggerMiddleware is a martini middleware to log each request throw our logger.
 func loggerMiddleware() martini.Handler {
 	return func(res http.ResponseWriter, req *http.Request, c martini.Context) {
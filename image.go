@@ -25,7 +25,5 @@ func getLastImage(w http.ResponseWriter, r *http.Request) {
 
 // GetLastImage returns the last image in database.
 func GetLastImage() (*Image, error) {
-	img := &Image{}
-	err := db.Last(img).Error
-	return img, err
+	return db.GetLastImage()
 }
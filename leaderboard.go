@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Periods of the leaderboards.
+const (
+	PeriodDay    = "day"
+	PeriodWeek   = "week"
+	PeriodMonth  = "month"
+	PeriodSeason = "season"
+	PeriodAll    = "all"
+)
+
+var errInvalidPeriod = errors.New("Invalid period, must be day, week, month, season or all")
+
+// Standing is a user with its rank in a leaderboard. The Points are the ones of the period.
+type Standing struct {
+	User
+	Rank int
+}
+
+// periodStart returns the start of the period containing now, in the timezone of the questions.
+// The weeks start on monday, and the all period at the zero time.
+func periodStart(period string, now time.Time) (time.Time, error) {
+	now = now.In(questionLocation)
+	year, month, day := now.Date()
+	switch period {
+	case PeriodDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, now.Location()), nil
+	case PeriodWeek:
+		days := (int(now.Weekday()) + 6) % 7
+		return time.Date(year, month, day-days, 0, 0, 0, 0, now.Location()), nil
+	case PeriodMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, now.Location()), nil
+	case PeriodAll:
+		return time.Time{}, nil
+	}
+	return time.Time{}, errInvalidPeriod
+}
+
+// leaderboardStart returns the start of the period containing now, or of the current
+// season for PeriodSeason. Without season, it is the start of the all period.
+func leaderboardStart(tx Store, period string, now time.Time) (time.Time, error) {
+	if period != PeriodSeason {
+		return periodStart(period, now)
+	}
+	season, err := tx.GetCurrentSeason()
+	if err == gorm.ErrRecordNotFound {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return season.StartedAt, nil
+}
+
+// GetLeaderboard returns the count first users by the points scored from the time until to,
+// or every user if count is 0. A zero to doesn't bound the points, and from a zero time they are
+// the points of the users. Ties are broken by the earliest right answer. The users who didn't
+// score are left out, except from a zero time.
+func GetLeaderboard(tx Store, from, to time.Time, count int) ([]Standing, error) {
+	users, err := tx.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	totals, err := tx.SumPointEvents(from, to)
+	if err != nil {
+		return nil, err
+	}
+	firstRightAnswers, err := tx.GetFirstRightAnswers(from, to)
+	if err != nil {
+		return nil, err
+	}
+	standings := make([]Standing, 0, len(users))
+	for _, user := range users {
+		if !from.IsZero() {
+			if totals[user.ID] <= 0 {
+				continue
+			}
+			user.Points = uint(totals[user.ID])
+		}
+		standings = append(standings, Standing{User: user})
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := &standings[i], &standings[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		ta, okA := firstRightAnswers[a.ID]
+		tb, okB := firstRightAnswers[b.ID]
+		if okA != okB {
+			return okA
+		}
+		return ta.Before(tb)
+	})
+	if count > 0 && len(standings) > count {
+		standings = standings[:count]
+	}
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings, nil
+}
+
+// GetSeasonLeaderboard returns the count first users by the points of the current season,
+// or of every period without season. It is the leaderboard of the TVs.
+func GetSeasonLeaderboard(tx Store, count int) ([]Standing, error) {
+	from, err := leaderboardStart(tx, PeriodSeason, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return GetLeaderboard(tx, from, time.Time{}, count)
+}
+
+// newStandings ranks the users in their order.
+func newStandings(users []User) []Standing {
+	standings := make([]Standing, len(users))
+	for i, user := range users {
+		standings[i] = Standing{User: user, Rank: i + 1}
+	}
+	return standings
+}
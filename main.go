@@ -1,14 +1,45 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"math/rand"
+	"os"
 	"time"
 )
 
+// commands contains the commands of the binary, run instead of the web service.
+var commands = map[string]func(w io.Writer, args []string) error{
+	"migrate":   migrateCommand,
+	"questions": questionsCommand,
+	"points":    pointsCommand,
+}
+
 func main() {
 	rand.Seed(time.Now().Unix())
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+	if err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
 	InitDB()
-	go refreshQuestion()
+	go scheduler.Run()
 	m := NewWebService()
 	m.Run()
 }
+
+// runCommand runs the command and returns the exit code.
+func runCommand(name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", name)
+		return 2
+	}
+	if err := cmd(os.Stdout, args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -14,11 +15,14 @@ type Message struct {
 	UserID  uint
 	Message string
 	SentAt  time.Time
+	Channel string `json:"-"`
+	SlackTS string `json:"-"`
 }
 
 // SlackMessageRequest contains the data of slack command request.
 type SlackMessageRequest struct {
 	Token     string `schema:"token"`
+	ChannelID string `schema:"channel_id"`
 	Timestamp string `schema:"timestamp"`
 	UserID    string `schema:"user_id"`
 	Text      string `schema:"text"`
@@ -26,37 +30,24 @@ type SlackMessageRequest struct {
 
 // GetMessagesRequest contains the data of slack command request.
 type GetMessagesRequest struct {
-	FromID int `schema:"from_id,omitempty"`
-	Count  int `schema:"count,omitempty"`
+	FromID uint `schema:"from_id,omitempty"`
+	Count  int  `schema:"count,omitempty"`
 }
 
 // addMessage adds a message in the database.
+// It handles the legacy outgoing webhooks, see slackEvents for the Events API.
 func addMessage(w http.ResponseWriter, r *http.Request) {
 	var req SlackMessageRequest
 	if err := decodeRequestForm(r, &req); err != nil {
 		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
 		return
 	}
-	if req.Token != slackOutgoingToken {
-		renderJSON(w, http.StatusBadRequest, errInvalidToken)
-		return
-	}
-	timestamp, err := strconv.ParseFloat(req.Timestamp, 32)
-	if err != nil {
+	if _, err := parseSlackTS(req.Timestamp); err != nil {
 		renderJSON(w, http.StatusBadRequest, errInvalidTimestamp)
-	}
-	user, err := GetUserBySlackID(req.UserID)
-	if err != nil {
-		renderJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	err = db.Create(&Message{
-		UserID:  user.ID,
-		Message: req.Text,
-		SentAt:  time.Unix(int64(timestamp), 0),
-	}).Error
-	if err != nil {
-		renderJSON(w, http.StatusInternalServerError, err.Error())
+	if err := AddSlackMessage(req.UserID, req.ChannelID, req.Timestamp, req.Text); err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -72,10 +63,52 @@ func getMessages(w http.ResponseWriter, r *http.Request) {
 		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
 		return
 	}
-	var messages []Message
-	if db.Order("id desc").Limit(req.Count).Find(&messages, "id > ?", req.FromID).Error != nil {
+	messages, err := db.GetMessages(req.FromID, req.Count)
+	if err != nil {
 		renderJSON(w, http.StatusNotFound, errMessagesNotFound)
 		return
 	}
 	renderJSON(w, http.StatusOK, messages)
 }
+
+// AddSlackMessage adds the message sent at ts by a slack user.
+func AddSlackMessage(slackUserID, channel, ts, text string) error {
+	sentAt, err := parseSlackTS(ts)
+	if err != nil {
+		return err
+	}
+	user, err := GetUserBySlackID(slackUserID)
+	if err != nil {
+		return err
+	}
+	message := &Message{
+		UserID:  user.ID,
+		Message: text,
+		SentAt:  sentAt,
+		Channel: channel,
+		SlackTS: ts,
+	}
+	if err := db.CreateMessage(message); err != nil {
+		return err
+	}
+	events.Publish(EventMessageCreated, message)
+	checkAchievements(TriggerMessage, user.ID)
+	return nil
+}
+
+// parseSlackTS parses a slack message timestamp, e.g. "1355517523.000005".
+func parseSlackTS(ts string) (time.Time, error) {
+	secStr, usecStr := ts, "0"
+	if i := strings.IndexRune(ts, '.'); i != -1 {
+		secStr, usecStr = ts[:i], ts[i+1:]
+	}
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	usec, err := strconv.ParseInt(usecStr, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, usec*int64(time.Microsecond)), nil
+}
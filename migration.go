@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var errNoMigrator = errors.New("The database driver has no versioned schema")
+
+// Migration is a versioned change of the database schema.
+// Migrations must keep their own copy of the models they change,
+// so that they still apply once the models moved on.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records a migration applied to the database.
+type SchemaMigration struct {
+	Version   uint `gorm:"primary_key"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationStatus tells if a migration has been applied.
+type MigrationStatus struct {
+	Version   uint
+	Name      string
+	AppliedAt time.Time
+}
+
+// Migrator is implemented by the stores with a versioned schema.
+type Migrator interface {
+	// MigrateUp applies every pending migration.
+	MigrateUp() error
+	// MigrateDown reverts the last applied migration.
+	MigrateDown() error
+	// MigrationStatus returns the status of every known migration.
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+func (s *sqlStore) appliedMigrations() (map[uint]SchemaMigration, error) {
+	if err := s.db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := s.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[uint]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func (s *sqlStore) MigrateUp() error {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := s.runMigration(m, m.Up, func(tx *gorm.DB) error {
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) MigrateDown() error {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		return s.runMigration(m, m.Down, func(tx *gorm.DB) error {
+			return tx.Delete(&SchemaMigration{Version: m.Version}).Error
+		})
+	}
+	return nil
+}
+
+// runMigration runs step then records it.
+// Migrations don't run in a transaction: MySQL commits schema changes
+// implicitly, and gorm checks the schema outside of the transaction.
+func (s *sqlStore) runMigration(m Migration, step, record func(tx *gorm.DB) error) error {
+	if err := step(s.db); err != nil {
+		return fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+	}
+	if err := record(s.db); err != nil {
+		return fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: applied[m.Version].AppliedAt}
+	}
+	return statuses, nil
+}
+
+// migrateCommand runs the migrate command: migrate up|down|status.
+func migrateCommand(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status")
+	}
+	store, err := OpenStore(dbDriver(), dbDSN())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	migrator, ok := store.(Migrator)
+	if !ok {
+		return errNoMigrator
+	}
+	switch args[0] {
+	case "up":
+		return migrator.MigrateUp()
+	case "down":
+		return migrator.MigrateDown()
+	case "status":
+		statuses, err := migrator.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if !status.AppliedAt.IsZero() {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown migrate command %q", args[0])
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// migrations lists every schema migration, ordered by version.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		Up: func(tx *gorm.DB) error {
+			// AutoMigrate keeps this migration a no-op on the databases
+			// created before the migrations existed.
+			return tx.AutoMigrate(&answer1{}, &answerEntry1{}, &image1{}, &message1{}, &question1{}, &user1{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&answer1{}, &answerEntry1{}, &image1{}, &message1{}, &question1{}, &user1{}).Error
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_answer_entries_question_id_user_id_index",
+		Up: func(tx *gorm.DB) error {
+			if err := deleteDuplicateAnswerEntries(tx); err != nil {
+				return err
+			}
+			return tx.Model(&answerEntry1{}).AddUniqueIndex("idx_answer_entries_question_id_user_id", "question_id", "user_id").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Model(&answerEntry1{}).RemoveIndex("idx_answer_entries_question_id_user_id").Error
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_messages_channel_slack_ts",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&message3{}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&message3{}).AddIndex("idx_messages_channel_slack_ts", "channel", "slack_ts").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Model(&message3{}).RemoveIndex("idx_messages_channel_slack_ts").Error; err != nil {
+				return err
+			}
+			return dropColumns(tx, &message3{}, "channel", "slack_ts")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_users_synced_at",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&user4{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &user4{}, "synced_at")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_questions_status",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&question5{}).Error; err != nil {
+				return err
+			}
+			// The questions asked before the moderation are kept in rotation.
+			if err := tx.Exec("UPDATE questions SET status = ?", "approved").Error; err != nil {
+				return err
+			}
+			return tx.Model(&question5{}).AddIndex("idx_questions_status", "status").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Model(&question5{}).RemoveIndex("idx_questions_status").Error; err != nil {
+				return err
+			}
+			return dropColumns(tx, &question5{}, "status", "reject_reason")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_users_role",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&user6{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &user6{}, "role")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_questions_scheduled_at",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&question7{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &question7{}, "scheduled_at")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_questions_ended_at",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&question8{}).Error; err != nil {
+				return err
+			}
+			return endStartedQuestions(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &question8{}, "ended_at")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_questions_category",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&question9{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &question9{}, "category")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_questions_tags",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&question10{}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&question10{}).AddIndex("idx_questions_category", "category").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Model(&question10{}).RemoveIndex("idx_questions_category").Error; err != nil {
+				return err
+			}
+			return dropColumns(tx, &question10{}, "tags")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create_point_events",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&pointEvent11{}, &user11{}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&pointEvent11{}).AddIndex("idx_point_events_user_id", "user_id").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.DropTableIfExists(&pointEvent11{}).Error; err != nil {
+				return err
+			}
+			return dropColumns(tx, &user11{}, "streak")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_point_events_note",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&pointEvent12{}).Error; err != nil {
+				return err
+			}
+			return addLegacyPointEvents(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Where("reason = ?", "legacy").Delete(&pointEvent12{}).Error; err != nil {
+				return err
+			}
+			return dropColumns(tx, &pointEvent12{}, "note")
+		},
+	},
+	{
+		Version: 13,
+		Name:    "create_seasons",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&season13{}, &seasonStanding13{}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&seasonStanding13{}).AddIndex("idx_season_standings_season_id", "season_id").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&seasonStanding13{}, &season13{}).Error
+		},
+	},
+	{
+		Version: 14,
+		Name:    "create_teams",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&team14{}, &user14{}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&user14{}).AddIndex("idx_users_team_id", "team_id").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Model(&user14{}).RemoveIndex("idx_users_team_id").Error; err != nil {
+				return err
+			}
+			if err := dropColumns(tx, &user14{}, "team_id"); err != nil {
+				return err
+			}
+			return tx.DropTableIfExists(&team14{}).Error
+		},
+	},
+	{
+		Version: 15,
+		Name:    "create_achievements",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&achievement15{}, &userAchievement15{}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&userAchievement15{}).AddUniqueIndex("idx_user_achievements_user_id_achievement_id", "user_id", "achievement_id").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&userAchievement15{}, &achievement15{}).Error
+		},
+	},
+	{
+		Version: 16,
+		Name:    "add_questions_skipped",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&question16{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &question16{}, "skipped")
+		},
+	},
+}
+
+// deleteDuplicateAnswerEntries keeps only the last answer of each user to each question.
+// The rows are read first, since MySQL can't delete from a table selected in the same statement.
+func deleteDuplicateAnswerEntries(tx *gorm.DB) error {
+	var entries []answerEntry1
+	if err := tx.Unscoped().Order("id DESC").Find(&entries).Error; err != nil {
+		return err
+	}
+	type key struct{ questionID, userID uint }
+	latest := make(map[key]bool)
+	var duplicates []uint
+	for _, entry := range entries {
+		k := key{entry.QuestionID, entry.UserID}
+		if latest[k] {
+			duplicates = append(duplicates, entry.ID)
+			continue
+		}
+		latest[k] = true
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+	return tx.Unscoped().Where("id IN (?)", duplicates).Delete(&answerEntry1{}).Error
+}
+
+// endStartedQuestions ends the questions asked before the ended_at column when the next one started.
+// The rows are read first, since MySQL can't update a table selected in the same statement.
+func endStartedQuestions(tx *gorm.DB) error {
+	var questions []question8
+	if err := tx.Unscoped().Where("started_at > ?", time.Time{}).Order("started_at").Find(&questions).Error; err != nil {
+		return err
+	}
+	for i := range questions {
+		for _, later := range questions[i+1:] {
+			if !later.StartedAt.After(questions[i].StartedAt) {
+				continue
+			}
+			if err := tx.Unscoped().Model(&questions[i]).UpdateColumn("ended_at", later.StartedAt).Error; err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// addLegacyPointEvents records the points given before the point events, so that
+// the points of every user are the sum of its events.
+func addLegacyPointEvents(tx *gorm.DB) error {
+	var users []user11
+	if err := tx.Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		var events []pointEvent12
+		if err := tx.Where("user_id = ?", user.ID).Find(&events).Error; err != nil {
+			return err
+		}
+		legacy := int(user.Points)
+		for _, event := range events {
+			legacy -= event.Points
+		}
+		if legacy == 0 {
+			continue
+		}
+		if err := tx.Create(&pointEvent12{UserID: user.ID, Points: legacy, Reason: "legacy"}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropColumns drops the columns of the table of model.
+func dropColumns(tx *gorm.DB, model interface{}, columns ...string) error {
+	for _, column := range columns {
+		if err := tx.Model(model).DropColumn(column).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tables as created by the migration 1.
+
+type answer1 struct {
+	gorm.Model
+	QuestionID uint
+	Sentence   string
+}
+
+func (answer1) TableName() string { return "answers" }
+
+type answerEntry1 struct {
+	gorm.Model
+	UserID     uint
+	QuestionID uint
+	AnswerID   uint
+}
+
+func (answerEntry1) TableName() string { return "answer_entries" }
+
+type image1 struct {
+	gorm.Model
+	UserID uint
+	URL    string
+}
+
+func (image1) TableName() string { return "images" }
+
+type message1 struct {
+	gorm.Model
+	UserID  uint
+	Message string
+	SentAt  time.Time
+}
+
+func (message1) TableName() string { return "messages" }
+
+type question1 struct {
+	gorm.Model
+	UserID        uint
+	Sentence      string
+	RightAnswerID uint
+	StartedAt     time.Time
+}
+
+func (question1) TableName() string { return "questions" }
+
+type user1 struct {
+	gorm.Model
+	SlackID   string `sql:"unique"`
+	FirstName string
+	LastName  string
+	ImageURL  string
+	Points    uint
+}
+
+func (user1) TableName() string { return "users" }
+
+// Tables as changed by the migration 3.
+
+type message3 struct {
+	gorm.Model
+	UserID  uint
+	Message string
+	SentAt  time.Time
+	Channel string
+	SlackTS string
+}
+
+func (message3) TableName() string { return "messages" }
+
+// Tables as changed by the migration 4.
+
+type user4 struct {
+	gorm.Model
+	SlackID   string `sql:"unique"`
+	FirstName string
+	LastName  string
+	ImageURL  string
+	Points    uint
+	SyncedAt  time.Time
+}
+
+func (user4) TableName() string { return "users" }
+
+// Tables as changed by the migration 5.
+
+type question5 struct {
+	gorm.Model
+	UserID        uint
+	Sentence      string
+	RightAnswerID uint
+	StartedAt     time.Time
+	Status        string
+	RejectReason  string
+}
+
+func (question5) TableName() string { return "questions" }
+
+// Tables as changed by the migration 6.
+
+type user6 struct {
+	gorm.Model
+	SlackID   string `sql:"unique"`
+	FirstName string
+	LastName  string
+	ImageURL  string
+	Points    uint
+	Role      string
+	SyncedAt  time.Time
+}
+
+func (user6) TableName() string { return "users" }
+
+// Tables as changed by the migration 7.
+
+type question7 struct {
+	gorm.Model
+	UserID        uint
+	Sentence      string
+	RightAnswerID uint
+	StartedAt     time.Time
+	Status        string
+	RejectReason  string
+	ScheduledAt   time.Time
+}
+
+func (question7) TableName() string { return "questions" }
+
+// Tables as changed by the migration 8.
+
+type question8 struct {
+	gorm.Model
+	UserID        uint
+	Sentence      string
+	RightAnswerID uint
+	StartedAt     time.Time
+	Status        string
+	RejectReason  string
+	ScheduledAt   time.Time
+	EndedAt       time.Time
+}
+
+func (question8) TableName() string { return "questions" }
+
+// Tables as changed by the migration 9.
+
+type question9 struct {
+	gorm.Model
+	UserID        uint
+	Sentence      string
+	Category      string
+	RightAnswerID uint
+	StartedAt     time.Time
+	Status        string
+	RejectReason  string
+	ScheduledAt   time.Time
+	EndedAt       time.Time
+}
+
+func (question9) TableName() string { return "questions" }
+
+// Tables as changed by the migration 10.
+
+type question10 struct {
+	gorm.Model
+	UserID        uint
+	Sentence      string
+	Category      string
+	Tags          string
+	RightAnswerID uint
+	StartedAt     time.Time
+	Status        string
+	RejectReason  string
+	ScheduledAt   time.Time
+	EndedAt       time.Time
+}
+
+func (question10) TableName() string { return "questions" }
+
+// Tables as changed by the migration 11.
+
+type pointEvent11 struct {
+	gorm.Model
+	UserID     uint
+	QuestionID uint
+	Points     int
+	Reason     string
+}
+
+func (pointEvent11) TableName() string { return "point_events" }
+
+type user11 struct {
+	gorm.Model
+	SlackID   string `sql:"unique"`
+	FirstName string
+	LastName  string
+	ImageURL  string
+	Points    uint
+	Streak    uint
+	Role      string
+	SyncedAt  time.Time
+}
+
+func (user11) TableName() string { return "users" }
+
+// Tables as changed by the migration 12.
+
+type pointEvent12 struct {
+	gorm.Model
+	UserID     uint
+	QuestionID uint
+	Points     int
+	Reason     string
+	Note       string
+}
+
+func (pointEvent12) TableName() string { return "point_events" }
+
+// Tables as created by the migration 13.
+
+type season13 struct {
+	gorm.Model
+	Name      string
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+func (season13) TableName() string { return "seasons" }
+
+type seasonStanding13 struct {
+	gorm.Model
+	SeasonID uint
+	UserID   uint
+	Position int
+	Points   uint
+}
+
+func (seasonStanding13) TableName() string { return "season_standings" }
+
+// Tables as changed by the migration 14.
+
+type team14 struct {
+	gorm.Model
+	Name             string `sql:"unique"`
+	SlackUsergroupID string
+}
+
+func (team14) TableName() string { return "teams" }
+
+type user14 struct {
+	gorm.Model
+	SlackID   string `sql:"unique"`
+	FirstName string
+	LastName  string
+	ImageURL  string
+	Points    uint
+	Streak    uint
+	TeamID    uint
+	Role      string
+	SyncedAt  time.Time
+}
+
+func (user14) TableName() string { return "users" }
+
+// Tables as created by the migration 15.
+
+type achievement15 struct {
+	gorm.Model
+	Code        string `sql:"unique"`
+	Name        string
+	Description string
+}
+
+func (achievement15) TableName() string { return "achievements" }
+
+type userAchievement15 struct {
+	gorm.Model
+	UserID        uint
+	AchievementID uint
+}
+
+func (userAchievement15) TableName() string { return "user_achievements" }
+
+// Tables as changed by the migration 16.
+
+type question16 struct {
+	gorm.Model
+	UserID        uint
+	Sentence      string
+	Category      string
+	Tags          string
+	RightAnswerID uint
+	StartedAt     time.Time
+	Status        string
+	RejectReason  string
+	ScheduledAt   time.Time
+	EndedAt       time.Time
+	Skipped       bool
+}
+
+func (question16) TableName() string { return "questions" }
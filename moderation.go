@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func slackCommandTVPending(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	resp := &SlackCommandResponse{}
+	questions, err := db.GetQuestionsByStatus(QuestionPending)
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't get pending questions: %v", err)
+		return resp
+	}
+	if len(questions) == 0 {
+		resp.Text = "No pending questions."
+		return resp
+	}
+	buff := bytes.NewBufferString("Pending questions:\n")
+	for _, question := range questions {
+		answers, err := GetAnswersByQuestionID(question.ID)
+		if err != nil {
+			resp.Text = fmt.Sprintf("Error: Can't get answers: %v", err)
+			return resp
+		}
+		rightAnswer := "unknown"
+		for _, answer := range answers {
+			if answer.ID == question.RightAnswerID {
+				rightAnswer = answer.Sentence
+			}
+		}
+		fmt.Fprintf(buff, "#%d %s\n%s (right answer: %s)\n", question.ID, question.Sentence, formatAnswers(answers), rightAnswer)
+	}
+	resp.Text = buff.String()
+	return resp
+}
+
+func slackCommandTVApprove(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	return moderateQuestion(req, user, QuestionApproved)
+}
+
+func slackCommandTVReject(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	return moderateQuestion(req, user, QuestionRejected)
+}
+
+// moderateQuestion sets the status of the question, e.g. "approve 12" or "reject 12 reason",
+// and tells the submitter.
+func moderateQuestion(req *SlackCommandRequest, user *User, status string) *SlackCommandResponse {
+	resp := &SlackCommandResponse{}
+	args := strings.SplitN(req.Text, " ", 3)
+	if len(args) < 2 {
+		resp.Text = commandTVUsage
+		return resp
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		resp.Text = "Error: Invalid question id"
+		return resp
+	}
+	question, err := db.GetQuestion(uint(id))
+	if err != nil {
+		resp.Text = "Error: Question not found"
+		return resp
+	}
+	if !question.StartedAt.IsZero() {
+		resp.Text = "Error: Question already asked"
+		return resp
+	}
+	question.Status = status
+	question.RejectReason = ""
+	if status == QuestionRejected && len(args) == 3 {
+		question.RejectReason = args[2]
+	}
+	if err := db.SaveQuestion(question); err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't update question: %v", err)
+		return resp
+	}
+	if err := notifySubmitter(question); err != nil {
+		log.WithFields(log.Fields{
+			"question_id": question.ID,
+			"err":         err,
+		}).Error("Can't notify question submitter")
+	}
+	resp.Text = fmt.Sprintf("Question #%d %s.", question.ID, status)
+	return resp
+}
+
+// notifySubmitter sends a direct message with the decision to the user who submitted the question.
+func notifySubmitter(question *Question) error {
+	submitter, err := GetUser(question.UserID)
+	if err != nil {
+		return err
+	}
+	if submitter.SlackID == "" {
+		return nil
+	}
+	text := fmt.Sprintf("Your question %q has been approved, it will soon be on the TV!", question.Sentence)
+	if question.Status == QuestionRejected {
+		text = fmt.Sprintf("Your question %q has been rejected.", question.Sentence)
+		if question.RejectReason != "" {
+			text = fmt.Sprintf("Your question %q has been rejected: %s", question.Sentence, question.RejectReason)
+		}
+	}
+	return slackClient.PostMessage(&SlackMessage{Channel: submitter.SlackID, Text: text})
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/jinzhu/gorm"
+)
+
+// Reasons of the point events not given by the scoring.
+const (
+	ReasonAdjustment = "adjustment"
+	ReasonReset      = "reset"
+	// ReasonLegacy are the points given before the events were recorded.
+	ReasonLegacy = "legacy"
+)
+
+// maxPointEventsCount is the maximum number of point events in a page of the points history.
+const maxPointEventsCount = 100
+
+var (
+	errInvalidPoints  = errors.New("Invalid points, must be a non zero integer")
+	errNegativePoints = errors.New("Points can't be negative")
+)
+
+// GetPointsHistoryRequest contains the data of get points history request.
+type GetPointsHistoryRequest struct {
+	Page  int `schema:"page"`
+	Count int `schema:"count"`
+}
+
+// PointsHistory is a page of the point events of a user, the last first.
+// Total counts the events of every page.
+type PointsHistory struct {
+	Points uint
+	Events []PointEvent
+	Page   int
+	Count  int
+	Total  int
+}
+
+// AdjustPointsRequest contains the data of adjust points request.
+type AdjustPointsRequest struct {
+	Points int    `schema:"points"`
+	Reason string `schema:"reason"`
+}
+
+// PointsCorrection is a user whose points didn't match its point events.
+type PointsCorrection struct {
+	User   User
+	Before uint
+	After  uint
+}
+
+// getPointsHistory returns the point events of a user.
+func getPointsHistory(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	id, err := strconv.ParseUint(params["user_id"], 10, 64)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, errInvalidUserID)
+		return
+	}
+	req := GetPointsHistoryRequest{
+		Page:  1,
+		Count: 20,
+	}
+	if err := decodeRequestQuery(r, &req); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	if req.Page < 1 || req.Count < 1 || req.Count > maxPointEventsCount {
+		renderJSON(w, http.StatusBadRequest, Error{"Invalid page or count, count must be at most " + strconv.Itoa(maxPointEventsCount)})
+		return
+	}
+	user, err := GetUser(uint(id))
+	if err != nil {
+		renderJSON(w, http.StatusNotFound, errUserNotFound)
+		return
+	}
+	events, total, err := db.GetPointEvents(user.ID, (req.Page-1)*req.Count, req.Count)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	if events == nil {
+		events = []PointEvent{}
+	}
+	renderJSON(w, http.StatusOK, &PointsHistory{Points: user.Points, Events: events, Page: req.Page, Count: req.Count, Total: total})
+}
+
+// adjustUserPoints gives points to a user, or takes them back.
+func adjustUserPoints(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	id, err := strconv.ParseUint(params["user_id"], 10, 64)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, errInvalidUserID)
+		return
+	}
+	var req AdjustPointsRequest
+	if err := decodeRequestForm(r, &req); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	event, err := adjustPoints(db, uint(id), req.Points, req.Reason)
+	switch err {
+	case nil:
+		publishLeaderboard()
+		renderJSON(w, http.StatusOK, event)
+	case gorm.ErrRecordNotFound:
+		renderJSON(w, http.StatusNotFound, errUserNotFound)
+	case errInvalidPoints, errNegativePoints:
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+	default:
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+	}
+}
+
+// recomputeUserPoints sets the points of the users to the sum of their point events.
+func recomputeUserPoints(w http.ResponseWriter, r *http.Request) {
+	corrections, err := recomputePoints(db)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	if len(corrections) > 0 {
+		publishLeaderboard()
+	}
+	renderJSON(w, http.StatusOK, corrections)
+}
+
+// adjustPoints gives points to the user, or takes them back if negative, with the reason in the note.
+func adjustPoints(s Store, userID uint, points int, note string) (*PointEvent, error) {
+	if points == 0 {
+		return nil, errInvalidPoints
+	}
+	event := &PointEvent{UserID: userID, Points: points, Reason: ReasonAdjustment, Note: note}
+	err := s.Transaction(func(tx Store) error {
+		user, err := tx.GetUser(userID)
+		if err != nil {
+			return err
+		}
+		if int(user.Points)+points < 0 {
+			return errNegativePoints
+		}
+		return tx.AddPointEvent(event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// resetPoints takes back the points of every user.
+func resetPoints(s Store) error {
+	return s.Transaction(func(tx Store) error {
+		users, err := tx.GetUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if user.Points == 0 {
+				continue
+			}
+			if err := tx.AddPointEvent(&PointEvent{UserID: user.ID, Points: -int(user.Points), Reason: ReasonReset}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// recomputePoints sets the points of every user to the sum of its point events,
+// and returns the users whose points changed.
+func recomputePoints(s Store) ([]PointsCorrection, error) {
+	corrections := []PointsCorrection{}
+	err := s.Transaction(func(tx Store) error {
+		totals, err := tx.SumPointEvents(time.Time{}, time.Time{})
+		if err != nil {
+			return err
+		}
+		users, err := tx.GetUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			points := uint(0)
+			if total := totals[user.ID]; total > 0 {
+				points = uint(total)
+			}
+			if points == user.Points {
+				continue
+			}
+			if err := tx.SetUserPoints(user.ID, points); err != nil {
+				return err
+			}
+			corrections = append(corrections, PointsCorrection{User: user, Before: user.Points, After: points})
+		}
+		return nil
+	})
+	return corrections, err
+}
+
+// slackCommandTVAdjustPoints gives points to a user, e.g. "adjust-points @john -5 double answer".
+func slackCommandTVAdjustPoints(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	resp := &SlackCommandResponse{}
+	args := strings.SplitN(req.Text, " ", 4)
+	if len(args) < 3 {
+		resp.Text = commandTVUsage
+		return resp
+	}
+	slackID := args[1]
+	if match := slackMentionRegexp.FindStringSubmatch(slackID); match != nil {
+		slackID = match[1]
+	}
+	points, err := strconv.Atoi(args[2])
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: %s", errInvalidPoints)
+		return resp
+	}
+	var note string
+	if len(args) == 4 {
+		note = strings.TrimSpace(args[3])
+	}
+	target, err := GetUserBySlackID(slackID)
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't get user: %v", err)
+		return resp
+	}
+	if _, err := adjustPoints(db, target.ID, points, note); err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't adjust points: %v", err)
+		return resp
+	}
+	publishLeaderboard()
+	resp.Text = fmt.Sprintf("%+d points for %s %s.", points, target.FirstName, target.LastName)
+	return resp
+}
+
+func slackCommandTVRecomputePoints(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	corrections, err := recomputePoints(db)
+	if err != nil {
+		return &SlackCommandResponse{Text: fmt.Sprintf("Error: Can't recompute points: %v", err)}
+	}
+	if len(corrections) > 0 {
+		publishLeaderboard()
+	}
+	return &SlackCommandResponse{Text: fmt.Sprintf("Points recomputed, %d users corrected.", len(corrections))}
+}
+
+// pointsCommand runs the points command: points recompute|adjust <slack_id> <points> [reason].
+func pointsCommand(w io.Writer, args []string) error {
+	usage := errors.New("usage: points recompute|adjust <slack_id> <points> [reason]")
+	if len(args) < 1 {
+		return usage
+	}
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	switch {
+	case args[0] == "recompute" && len(args) == 1:
+		corrections, err := recomputePoints(store)
+		if err != nil {
+			return err
+		}
+		for _, c := range corrections {
+			fmt.Fprintf(w, "%s %s %s: %d -> %d\n", c.User.SlackID, c.User.FirstName, c.User.LastName, c.Before, c.After)
+		}
+		fmt.Fprintf(w, "%d users corrected\n", len(corrections))
+		return nil
+	case args[0] == "adjust" && len(args) >= 3:
+		user, err := store.GetUserBySlackID(args[1])
+		if err != nil {
+			return fmt.Errorf("Can't get user %s: %v", args[1], err)
+		}
+		points, err := strconv.Atoi(args[2])
+		if err != nil {
+			return errInvalidPoints
+		}
+		_, err = adjustPoints(store, user.ID, points, strings.Join(args[3:], " "))
+		return err
+	}
+	return usage
+}
@@ -2,30 +2,61 @@ package main
 
 import (
 	"errors"
-	"math/rand"
+	"fmt"
 	"net/http"
-	"os"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/jinzhu/gorm"
 )
 
-var errNoQuestionAvailable = errors.New("No question available")
+// Statuses of a question. Only the approved questions are rotated.
+const (
+	QuestionPending  = "pending"
+	QuestionApproved = "approved"
+	QuestionRejected = "rejected"
+)
+
+var (
+	errNoQuestionAvailable  = errors.New("No question available")
+	errQuestionNotFound     = errors.New("Question not found")
+	errQuestionNotStartable = errors.New("Question not approved or already asked")
+	errRevealInProgress     = errors.New("The results of the last question are being revealed")
+)
 
 // Question contains information about a question.
 type Question struct {
 	gorm.Model
 	UserID        uint
 	Sentence      string
+	Category      string
+	Tags          string
 	RightAnswerID uint `json:"-"`
 	StartedAt     time.Time
+	Status        string
+	RejectReason  string    `json:"-"`
+	ScheduledAt   time.Time `json:"-"`
+	EndedAt       time.Time
+	// Skipped is set when the question ended without scoring the answers.
+	Skipped bool `json:"-"`
 }
 
+// Limits of the questions and their answers.
+const (
+	maxQuestionLength = 128
+	maxAnswerLength   = 32
+	minAnswers        = 2
+	maxAnswers        = 4
+)
+
 // GetCurrentQuestionAnswer contains the data of get current question request.
 type GetCurrentQuestionAnswer struct {
 	Question *Question
 	Answers  []string
+	// Reveal is set once the question ended, until the next one starts.
+	Reveal *QuestionReveal `json:",omitempty"`
 }
 
 // getCurrentQuestion returns current question.
@@ -35,76 +66,234 @@ func getCurrentQuestion(w http.ResponseWriter, r *http.Request) {
 		renderJSON(w, http.StatusNotFound, errCurQuestionNotFound)
 		return
 	}
-	answers, err := GetAnswersByQuestionID(question.ID)
+	resp, err := newCurrentQuestionAnswer(db, question)
 	if err != nil {
 		renderJSON(w, http.StatusNotFound, errCurQuestionNotFound)
 		return
 	}
-	resp := GetCurrentQuestionAnswer{Question: question}
+	renderJSON(w, http.StatusOK, resp)
+}
+
+// newCurrentQuestionAnswer returns the question with its answers.
+func newCurrentQuestionAnswer(tx Store, question *Question) (*GetCurrentQuestionAnswer, error) {
+	answers, err := tx.GetAnswersByQuestionID(question.ID)
+	if err != nil {
+		return nil, err
+	}
+	resp := &GetCurrentQuestionAnswer{Question: question}
 	for _, answer := range answers {
 		resp.Answers = append(resp.Answers, answer.Sentence)
 	}
-	renderJSON(w, http.StatusOK, &resp)
+	if !question.EndedAt.IsZero() {
+		results, err := GetQuestionResults(tx, question)
+		if err != nil {
+			return nil, err
+		}
+		resp.Reveal = &QuestionReveal{Results: results, Until: question.EndedAt.Add(revealDuration)}
+	}
+	return resp, nil
+}
+
+// validateQuestion checks a new question with its answers and the index of the right one, starting from 1.
+func validateQuestion(sentence string, answers []string, rightAnswer int) error {
+	if len(answers) < minAnswers || len(answers) > maxAnswers {
+		return fmt.Errorf("Can't set %d answers: Minimum %d answers and maximum %d answers", len(answers), minAnswers, maxAnswers)
+	}
+	if strings.TrimSpace(sentence) == "" {
+		return errors.New("Question is empty")
+	}
+	if utf8.RuneCountInString(sentence) > maxQuestionLength {
+		return fmt.Errorf("Question is too long maximum %d characters", maxQuestionLength)
+	}
+	if rightAnswer <= 0 || rightAnswer > len(answers) {
+		return errors.New("Invalid right answer index")
+	}
+	for i, answer := range answers {
+		if strings.TrimSpace(answer) == "" {
+			return fmt.Errorf("Answer %d is empty", i+1)
+		}
+		if utf8.RuneCountInString(answer) > maxAnswerLength {
+			return fmt.Errorf("Answer %d is too long maximum %d characters", i+1, maxAnswerLength)
+		}
+	}
+	return nil
+}
+
+// createQuestion creates the question with its answers. rightAnswer is the index
+// of the right one, starting from 1.
+func createQuestion(tx Store, question *Question, answers []string, rightAnswer int) error {
+	if err := tx.CreateQuestion(question); err != nil {
+		return fmt.Errorf("Can't create question: %s", err)
+	}
+	for i, sentence := range answers {
+		answer := &Answer{QuestionID: question.ID, Sentence: sentence}
+		if err := tx.CreateAnswer(answer); err != nil {
+			return fmt.Errorf("Can't create answer: %s", err)
+		}
+		if i == rightAnswer-1 {
+			question.RightAnswerID = answer.ID
+		}
+	}
+	if err := tx.SaveQuestion(question); err != nil {
+		return fmt.Errorf("Can't update question right_answer_id: %s", err)
+	}
+	return nil
 }
 
 // GetCurrentQuestion returns the current question.
 func GetCurrentQuestion() (*Question, error) {
-	return getCurrentQuestionWithTX(db)
+	return db.GetCurrentQuestion()
 }
 
-// getCurrentQuestionWithTX returns the current question using database transaction.
-func getCurrentQuestionWithTX(tx *gorm.DB) (*Question, error) {
-	question := &Question{}
-	err := tx.Order("started_at").Last(question).Error
-	return question, err
+// nextQuestion selects a new random question, updates users points
+// and announces the rotation.
+func nextQuestion() error {
+	_, err := rotateQuestion(0, true)
+	return err
 }
 
-// refreshQuestion updates current question every X time.
-func refreshQuestion() {
-	questionRefreshRate := os.Getenv("QUESTION_REFRESH_RATE")
-	wait, err := time.ParseDuration(questionRefreshRate)
+// rotateQuestion starts the question with the id, or a random one if id is 0,
+// and announces the rotation. The current question ends, and unless score is false
+// the users points are updated and its results are revealed for revealDuration
+// before the next question starts. It returns the next question.
+func rotateQuestion(id uint, score bool) (*Question, error) {
+	var previous, next *Question
+	now := time.Now()
+	err := db.Transaction(func(tx Store) error {
+		current, err := tx.GetCurrentQuestion()
+		if err != nil || current.StartedAt.IsZero() {
+			current = nil
+		} else if !current.EndedAt.IsZero() && now.Before(current.EndedAt.Add(revealDuration)) {
+			return errRevealInProgress
+		}
+		if id == 0 {
+			next, err = getNextQuestion(tx)
+		} else {
+			next, err = getStartableQuestion(tx, id)
+		}
+		if err != nil {
+			return err
+		}
+		startAt := now
+		if current != nil && current.EndedAt.IsZero() {
+			if err := endQuestion(tx, current, now, score); err != nil {
+				return err
+			}
+			if score {
+				previous = current
+				startAt = now.Add(revealDuration)
+			}
+		}
+		return startQuestion(tx, next, startAt)
+	})
 	if err != nil {
-		log.Fatal("Can't convert question refresh rate")
+		return nil, err
+	}
+	if previous != nil {
+		publishReveal(previous)
+		publishLeaderboard()
+		checkRoundAchievements(previous)
 	}
-	for {
-		time.Sleep(wait)
-		if err := nextQuestion(); err != nil {
-			log.WithField("err", err).Error("Can't set nextQuestion")
+	afterReveal(previous, func() {
+		publishQuestion(next)
+		if err := announceRotation(previous, next); err != nil {
+			log.WithField("err", err).Error("Can't announce question rotation")
+		}
+	})
+	return next, nil
+}
+
+// endQuestion ends the question at now, and scores the answers if score is true.
+// Otherwise the question is marked as skipped.
+func endQuestion(tx Store, question *Question, now time.Time, score bool) error {
+	if score {
+		if err := scoreQuestion(tx, question); err != nil {
+			return err
 		}
 	}
+	question.EndedAt = now
+	question.Skipped = !score
+	return tx.SaveQuestion(question)
 }
 
-// nextQuestion selects a new random question and updates users points.
-func nextQuestion() error {
-	tx := db.Begin()
-	defer tx.Commit()
-	nextQuestion, err := getNextQuestion(tx)
+// startQuestion makes the question the current one from startAt.
+func startQuestion(tx Store, question *Question, startAt time.Time) error {
+	question.StartedAt = startAt
+	question.ScheduledAt = time.Time{}
+	return tx.SaveQuestion(question)
+}
+
+// afterReveal runs fn once the results of the previous question were revealed.
+// previous is nil when nothing is revealed.
+func afterReveal(previous *Question, fn func()) {
+	if previous == nil || revealDuration <= 0 {
+		fn()
+		return
+	}
+	time.AfterFunc(revealDuration, fn)
+}
+
+// publishQuestion pushes the next question.
+func publishQuestion(next *Question) {
+	current, err := newCurrentQuestionAnswer(db, next)
 	if err != nil {
-		return err
+		log.WithField("err", err).Error("Can't get answers")
+		return
 	}
-	if err := updateUsersPoints(tx); err != nil {
-		return err
+	events.Publish(EventQuestionRotated, current)
+}
+
+// publishReveal pushes the results of the previous question.
+func publishReveal(previous *Question) {
+	results, err := GetQuestionResults(db, previous)
+	if err != nil {
+		log.WithField("err", err).Error("Can't get results")
+		return
 	}
-	return tx.Model(nextQuestion).UpdateColumn("started_at", time.Now()).Error
+	events.Publish(EventQuestionRevealed, &QuestionReveal{Results: results, Until: previous.EndedAt.Add(revealDuration)})
 }
 
-// updateUsersPoints updates users points.
-func updateUsersPoints(tx *gorm.DB) error {
-	q, err := getCurrentQuestionWithTX(tx)
+// publishLeaderboard pushes the users top of the season.
+func publishLeaderboard() {
+	standings, err := GetSeasonLeaderboard(db, leaderboardSize)
 	if err != nil {
-		return nil
+		log.WithField("err", err).Error("Can't get users top")
+		return
 	}
-	return tx.Exec("UPDATE `users` JOIN `answer_entries` ON users.id = answer_entries.user_id SET users.points = users.points + 1 WHERE answer_entries.question_id = ? AND answer_entries.answer_id = ?", q.ID, q.RightAnswerID).Error
+	events.Publish(EventLeaderboardChanged, standings)
 }
 
-// getNextQuestion returns the next random question.
-func getNextQuestion(tx *gorm.DB) (*Question, error) {
-	var questions []Question
-	if err := tx.Where("started_at = ?", 0).Find(&questions).Error; err != nil {
+// getNextQuestion returns the next question picked by the rotation policy.
+// The scheduled questions are kept for their time.
+func getNextQuestion(tx Store) (*Question, error) {
+	unstarted, err := tx.GetUnstartedQuestions()
+	if err != nil {
 		return nil, err
 	}
+	var questions []Question
+	for _, question := range unstarted {
+		if question.ScheduledAt.IsZero() {
+			questions = append(questions, question)
+		}
+	}
 	if len(questions) == 0 {
 		return nil, errNoQuestionAvailable
 	}
-	return &questions[rand.Intn(len(questions))], nil
+	var last string
+	if current, err := tx.GetCurrentQuestion(); err == nil {
+		last = current.Category
+	}
+	return questionPolicy.Pick(questions, last, time.Now()), nil
+}
+
+// getStartableQuestion returns the question with the id if it's approved and not started yet.
+func getStartableQuestion(tx Store, id uint) (*Question, error) {
+	question, err := tx.GetQuestion(id)
+	if err != nil {
+		return nil, errQuestionNotFound
+	}
+	if question.Status != QuestionApproved || !question.StartedAt.IsZero() {
+		return nil, errQuestionNotStartable
+	}
+	return question, nil
 }
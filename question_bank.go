@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Formats of the question banks.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatYAML = "yaml"
+)
+
+var (
+	errInvalidFormat = errors.New("Invalid format, must be json, csv or yaml")
+
+	// csvColumns are the columns of the CSV question banks. Only sentence is required.
+	csvColumns = []string{"sentence", "answer_1", "answer_2", "answer_3", "answer_4", "right_answer", "category", "tags", "author"}
+
+	formatContentTypes = map[string]string{
+		FormatJSON: ContentJSON,
+		FormatCSV:  "text/csv" + defaultCharset,
+		FormatYAML: "application/x-yaml" + defaultCharset,
+	}
+)
+
+// QuestionBankEntry is a question of a question bank. RightAnswer is the index of the
+// right answer, starting from 1, and Author the Slack ID of the user who wrote it.
+type QuestionBankEntry struct {
+	Sentence    string   `json:"sentence" yaml:"sentence"`
+	Answers     []string `json:"answers" yaml:"answers"`
+	RightAnswer int      `json:"right_answer" yaml:"right_answer"`
+	Category    string   `json:"category,omitempty" yaml:"category,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Author      string   `json:"author,omitempty" yaml:"author,omitempty"`
+}
+
+// ImportReport tells how many questions were created, and skipped because they already exist.
+type ImportReport struct {
+	Created int
+	Skipped int
+	Errors  []ImportError
+}
+
+// ImportError is a question which can't be imported.
+// Row is the position of the question in the bank, starting from 1.
+type ImportError struct {
+	Row   int
+	Error string
+}
+
+// ImportQuestions creates the approved questions of the bank. The questions with the
+// sentence of an existing question are skipped, so that importing a bank twice is harmless.
+func ImportQuestions(s Store, entries []QuestionBankEntry) *ImportReport {
+	report := &ImportReport{}
+	for i := range entries {
+		created, err := importQuestion(s, &entries[i])
+		switch {
+		case err != nil:
+			report.Errors = append(report.Errors, ImportError{Row: i + 1, Error: err.Error()})
+		case created:
+			report.Created++
+		default:
+			report.Skipped++
+		}
+	}
+	return report
+}
+
+// importQuestion creates the question unless it exists.
+func importQuestion(s Store, entry *QuestionBankEntry) (created bool, err error) {
+	if err := validateQuestion(entry.Sentence, entry.Answers, entry.RightAnswer); err != nil {
+		return false, err
+	}
+	category, tags := normalizeCategory(entry.Category), normalizeTags(entry.Tags)
+	if err := validateCategory(category, tags); err != nil {
+		return false, err
+	}
+	err = s.Transaction(func(tx Store) error {
+		if _, err := tx.GetQuestionBySentence(entry.Sentence); err != gorm.ErrRecordNotFound {
+			return err
+		}
+		userID, err := importAuthor(tx, entry.Author)
+		if err != nil {
+			return err
+		}
+		question := &Question{
+			UserID:   userID,
+			Sentence: entry.Sentence,
+			Category: category,
+			Tags:     tags,
+			Status:   QuestionApproved,
+		}
+		created = true
+		return createQuestion(tx, question, entry.Answers, entry.RightAnswer)
+	})
+	return created && err == nil, err
+}
+
+// importAuthor returns the id of the user with the Slack ID, or 0 if there is none.
+// Unknown users are created without profile, which is fetched from Slack when they're first used.
+func importAuthor(tx Store, slackID string) (uint, error) {
+	if slackID == "" {
+		return 0, nil
+	}
+	user, err := tx.GetUserBySlackID(slackID)
+	if err == gorm.ErrRecordNotFound {
+		user = &User{SlackID: slackID}
+		err = tx.SaveUserProfile(user)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Can't get author: %v", err)
+	}
+	return user.ID, nil
+}
+
+// ExportQuestions returns the approved questions as a question bank.
+func ExportQuestions(s Store) ([]QuestionBankEntry, error) {
+	questions, err := s.GetQuestionsByStatus(QuestionApproved)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]QuestionBankEntry, 0, len(questions))
+	for _, question := range questions {
+		answers, err := s.GetAnswersByQuestionID(question.ID)
+		if err != nil {
+			return nil, err
+		}
+		entry := QuestionBankEntry{Sentence: question.Sentence, Category: question.Category, Tags: splitTags(question.Tags)}
+		for i, answer := range answers {
+			entry.Answers = append(entry.Answers, answer.Sentence)
+			if answer.ID == question.RightAnswerID {
+				entry.RightAnswer = i + 1
+			}
+		}
+		if question.UserID != 0 {
+			user, err := s.GetUser(question.UserID)
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return nil, err
+			}
+			entry.Author = user.SlackID
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// decodeQuestionBank reads a question bank in the format.
+func decodeQuestionBank(r io.Reader, format string) ([]QuestionBankEntry, error) {
+	var entries []QuestionBankEntry
+	switch format {
+	case FormatJSON:
+		err := json.NewDecoder(r).Decode(&entries)
+		return entries, err
+	case FormatYAML:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.Unmarshal(data, &entries)
+		return entries, err
+	case FormatCSV:
+		return decodeCSVQuestionBank(r)
+	}
+	return nil, errInvalidFormat
+}
+
+// decodeCSVQuestionBank reads a CSV question bank. The first row names the columns.
+// An invalid right answer index or a row missing fields is left to the validation,
+// to be reported with its row.
+func decodeCSVQuestionBank(r io.Reader) ([]QuestionBankEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["sentence"]; !ok {
+		return nil, errors.New("Missing sentence column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	var entries []QuestionBankEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entry := QuestionBankEntry{
+			Sentence: field(record, "sentence"),
+			Category: field(record, "category"),
+			Author:   field(record, "author"),
+		}
+		entry.RightAnswer, _ = strconv.Atoi(field(record, "right_answer"))
+		if tags := field(record, "tags"); tags != "" {
+			entry.Tags = strings.Split(tags, ",")
+		}
+		for i := 1; i <= maxAnswers; i++ {
+			if answer := field(record, "answer_"+strconv.Itoa(i)); answer != "" {
+				entry.Answers = append(entry.Answers, answer)
+			}
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// encodeQuestionBank writes the question bank in the format.
+func encodeQuestionBank(w io.Writer, format string, entries []QuestionBankEntry) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case FormatYAML:
+		data, err := yaml.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(csvColumns)
+		for _, entry := range entries {
+			record := []string{entry.Sentence}
+			for i := 0; i < maxAnswers; i++ {
+				answer := ""
+				if i < len(entry.Answers) {
+					answer = entry.Answers[i]
+				}
+				record = append(record, answer)
+			}
+			record = append(record, strconv.Itoa(entry.RightAnswer), entry.Category, strings.Join(entry.Tags, ","), entry.Author)
+			writer.Write(record)
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return errInvalidFormat
+}
+
+// questionBankFormat returns the format of the file named path, json by default.
+func questionBankFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return FormatJSON
+}
+
+// importQuestionBank imports the question bank of the request body
+// in the format query parameter, json by default.
+func importQuestionBank(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatJSON
+	}
+	entries, err := decodeQuestionBank(r.Body, format)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	renderJSON(w, http.StatusOK, ImportQuestions(db, entries))
+}
+
+// exportQuestionBank returns the approved questions in the format query parameter, json by default.
+func exportQuestionBank(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatJSON
+	}
+	contentType, ok := formatContentTypes[format]
+	if !ok {
+		renderJSON(w, http.StatusBadRequest, Error{errInvalidFormat.Error()})
+		return
+	}
+	entries, err := ExportQuestions(db)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	w.Header().Set(ContentType, contentType)
+	w.WriteHeader(http.StatusOK)
+	encodeQuestionBank(w, format, entries)
+}
+
+// questionsCommand runs the questions command:
+// questions import|export [-format json|csv|yaml] [file].
+// The format defaults to the extension of the file, which defaults to stdin or stdout.
+func questionsCommand(w io.Writer, args []string) error {
+	usage := errors.New("usage: questions import|export [-format json|csv|yaml] [file]")
+	if len(args) < 1 {
+		return usage
+	}
+	flags := flag.NewFlagSet("questions "+args[0], flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	format := flags.String("format", "", "json, csv or yaml")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() > 1 {
+		return usage
+	}
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = questionBankFormat(path)
+	}
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	switch args[0] {
+	case "import":
+		return importCommand(w, store, path, *format)
+	case "export":
+		return exportCommand(w, store, path, *format)
+	}
+	return usage
+}
+
+// importCommand imports the question bank of the file, or stdin if path is empty or "-".
+func importCommand(w io.Writer, store Store, path, format string) error {
+	r := os.Stdin
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	entries, err := decodeQuestionBank(r, format)
+	if err != nil {
+		return err
+	}
+	report := ImportQuestions(store, entries)
+	for _, rowErr := range report.Errors {
+		fmt.Fprintf(w, "row %d: %s\n", rowErr.Row, rowErr.Error)
+	}
+	fmt.Fprintf(w, "%d created, %d skipped, %d failed\n", report.Created, report.Skipped, len(report.Errors))
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("%d questions not imported", len(report.Errors))
+	}
+	return nil
+}
+
+// exportCommand exports the questions to the file, or w if path is empty or "-".
+func exportCommand(w io.Writer, store Store, path, format string) error {
+	entries, err := ExportQuestions(store)
+	if err != nil {
+		return err
+	}
+	if path == "" || path == "-" {
+		return encodeQuestionBank(w, format, entries)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeQuestionBank(f, format, entries); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// States of an approved question in the rotation.
+const (
+	QuestionUpcoming = "upcoming"
+	QuestionCurrent  = "current"
+	QuestionPast     = "past"
+)
+
+const maxQuestionsCount = 100
+
+var errInvalidDate = errors.New("Invalid date, e.g. 2017-03-01 or 2017-03-01T14:30:00+01:00")
+
+// GetQuestionsRequest contains the data of get questions request.
+// From and To are dates or RFC 3339 times bounding the start of the questions.
+type GetQuestionsRequest struct {
+	Status   string `schema:"status"`
+	UserID   uint   `schema:"user_id"`
+	Category string `schema:"category"`
+	From     string `schema:"from"`
+	To       string `schema:"to"`
+	Page     int    `schema:"page"`
+	Count    int    `schema:"count"`
+}
+
+// GetQuestionsResponse is a page of questions. Total counts the questions of every page.
+type GetQuestionsResponse struct {
+	Questions []QuestionDetail
+	Page      int
+	Count     int
+	Total     int
+}
+
+// QuestionDetail is an approved question with its answers.
+// The results are only given once the question ended.
+type QuestionDetail struct {
+	Question *Question
+	State    string
+	Answers  []string
+	Results  *QuestionResults `json:",omitempty"`
+}
+
+// QuestionFilter selects the approved questions. The zero values match every question.
+type QuestionFilter struct {
+	State    string
+	UserID   uint
+	Category string
+	From     time.Time
+	To       time.Time
+	Offset   int
+	Limit    int
+}
+
+// match reports whether the question is selected by the filter at now.
+func (f *QuestionFilter) match(question *Question, now time.Time) bool {
+	if question.Status != QuestionApproved {
+		return false
+	}
+	if f.State != "" && questionState(question, now) != f.State {
+		return false
+	}
+	if f.UserID != 0 && question.UserID != f.UserID {
+		return false
+	}
+	if f.Category != "" && question.Category != f.Category {
+		return false
+	}
+	if !f.From.IsZero() && question.StartedAt.Before(f.From) {
+		return false
+	}
+	return f.To.IsZero() || question.StartedAt.Before(f.To)
+}
+
+// questionState returns whether the question is upcoming, current or past at now.
+func questionState(question *Question, now time.Time) string {
+	switch {
+	case !question.EndedAt.IsZero():
+		return QuestionPast
+	case question.StartedAt.IsZero() || question.StartedAt.After(now):
+		return QuestionUpcoming
+	}
+	return QuestionCurrent
+}
+
+// getQuestions returns a page of the approved questions, the last started first.
+func getQuestions(w http.ResponseWriter, r *http.Request) {
+	req := GetQuestionsRequest{
+		Page:  1,
+		Count: 20,
+	}
+	if err := decodeRequestQuery(r, &req); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	filter, err := newQuestionFilter(&req)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	questions, total, err := db.GetQuestions(filter)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	resp := &GetQuestionsResponse{
+		Questions: make([]QuestionDetail, 0, len(questions)),
+		Page:      req.Page,
+		Count:     req.Count,
+		Total:     total,
+	}
+	for i := range questions {
+		detail, err := newQuestionDetail(db, &questions[i])
+		if err != nil {
+			renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+		resp.Questions = append(resp.Questions, *detail)
+	}
+	renderJSON(w, http.StatusOK, resp)
+}
+
+// getQuestion returns an approved question.
+func getQuestion(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	id, err := strconv.ParseUint(params["question_id"], 10, 64)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, errInvalidQuestionID)
+		return
+	}
+	question, err := db.GetQuestion(uint(id))
+	if err != nil || question.Status != QuestionApproved {
+		renderJSON(w, http.StatusNotFound, Error{errQuestionNotFound.Error()})
+		return
+	}
+	detail, err := newQuestionDetail(db, question)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	renderJSON(w, http.StatusOK, detail)
+}
+
+// newQuestionFilter checks the request and returns the filter of its page.
+func newQuestionFilter(req *GetQuestionsRequest) (QuestionFilter, error) {
+	filter := QuestionFilter{State: req.Status, UserID: req.UserID, Category: normalizeCategory(req.Category)}
+	switch req.Status {
+	case "", QuestionUpcoming, QuestionCurrent, QuestionPast:
+	default:
+		return filter, errors.New("Invalid status, must be upcoming, current or past")
+	}
+	if req.Page < 1 || req.Count < 1 || req.Count > maxQuestionsCount {
+		return filter, errors.New("Invalid page or count, count must be at most " + strconv.Itoa(maxQuestionsCount))
+	}
+	var err error
+	if filter.From, err = parseDate(req.From); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseDate(req.To); err != nil {
+		return filter, err
+	}
+	filter.Offset = (req.Page - 1) * req.Count
+	filter.Limit = req.Count
+	return filter, nil
+}
+
+// parseDate parses a date of the questions timezone or an RFC 3339 time. An empty value is the zero time.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, questionLocation)
+	if err != nil {
+		return time.Time{}, errInvalidDate
+	}
+	return t, nil
+}
+
+// newQuestionDetail returns the question with its answers, and its results if it ended.
+func newQuestionDetail(tx Store, question *Question) (*QuestionDetail, error) {
+	answers, err := tx.GetAnswersByQuestionID(question.ID)
+	if err != nil {
+		return nil, err
+	}
+	detail := &QuestionDetail{Question: question, State: questionState(question, time.Now())}
+	for _, answer := range answers {
+		detail.Answers = append(detail.Answers, answer.Sentence)
+	}
+	if detail.State == QuestionPast {
+		if detail.Results, err = GetQuestionResults(tx, question); err != nil {
+			return nil, err
+		}
+	}
+	return detail, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+var (
+	// revealDuration is how long the results of a question are shown before the next question starts.
+	revealDuration time.Duration
+
+	errQuestionNotEnded = errors.New("Question not ended yet")
+)
+
+// AnswerResult is how many people gave an answer.
+// Percent is relative to the number of people who answered.
+type AnswerResult struct {
+	AnswerID uint
+	Sentence string
+	Count    int
+	Percent  float64
+	Right    bool
+}
+
+// QuestionResults contains the right answer of a question, how people answered
+// and who got it right, in the order they first answered.
+type QuestionResults struct {
+	Question      *Question
+	RightAnswerID uint
+	RightAnswer   string
+	Total         int
+	Answers       []AnswerResult
+	Winners       []User
+}
+
+// QuestionReveal contains the results of the question shown until the next question starts.
+type QuestionReveal struct {
+	Results *QuestionResults
+	Until   time.Time
+}
+
+// getQuestionResults returns the results of an ended question.
+func getQuestionResults(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	id, err := strconv.ParseUint(params["question_id"], 10, 64)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, errInvalidQuestionID)
+		return
+	}
+	question, err := db.GetQuestion(uint(id))
+	if err != nil {
+		renderJSON(w, http.StatusNotFound, Error{errQuestionNotFound.Error()})
+		return
+	}
+	if question.EndedAt.IsZero() {
+		renderJSON(w, http.StatusConflict, Error{errQuestionNotEnded.Error()})
+		return
+	}
+	results, err := GetQuestionResults(db, question)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	renderJSON(w, http.StatusOK, results)
+}
+
+// GetQuestionResults counts the answers given to the question.
+func GetQuestionResults(tx Store, question *Question) (*QuestionResults, error) {
+	answers, err := tx.GetAnswersByQuestionID(question.ID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := tx.GetAnswerEntriesByQuestionID(question.ID)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]int, len(answers))
+	for _, entry := range entries {
+		counts[entry.AnswerID]++
+	}
+	results := &QuestionResults{
+		Question:      question,
+		RightAnswerID: question.RightAnswerID,
+		Total:         len(entries),
+		Answers:       make([]AnswerResult, 0, len(answers)),
+		Winners:       make([]User, 0, counts[question.RightAnswerID]),
+	}
+	for _, answer := range answers {
+		result := AnswerResult{
+			AnswerID: answer.ID,
+			Sentence: answer.Sentence,
+			Count:    counts[answer.ID],
+			Right:    answer.ID == question.RightAnswerID,
+		}
+		if results.Total > 0 {
+			result.Percent = math.Round(float64(result.Count)*1000/float64(results.Total)) / 10
+		}
+		if result.Right {
+			results.RightAnswer = answer.Sentence
+		}
+		results.Answers = append(results.Answers, result)
+	}
+	for _, entry := range entries {
+		if entry.AnswerID != question.RightAnswerID {
+			continue
+		}
+		user, err := tx.GetUser(entry.UserID)
+		if err != nil {
+			return nil, err
+		}
+		results.Winners = append(results.Winners, *user)
+	}
+	return results, nil
+}
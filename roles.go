@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Roles of a user, from the least to the most privileged.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var (
+	roleRanks = map[string]int{
+		RoleUser:      0,
+		RoleModerator: 1,
+		RoleAdmin:     2,
+	}
+
+	// adminSlackIDs and moderatorSlackIDs grant their role whatever is stored,
+	// so that the first admins can give the roles with /tv role.
+	adminSlackIDs     = parseSlackIDs(os.Getenv("ADMIN_SLACK_IDS"))
+	moderatorSlackIDs = parseSlackIDs(os.Getenv("MODERATOR_SLACK_IDS"))
+
+	scheduleTimeLayout = "2006-01-02 15:04"
+
+	// slackMentionRegexp matches a user mention, e.g. "<@U123|john>".
+	slackMentionRegexp = regexp.MustCompile(`^<@([A-Z0-9]+)(\|[^>]*)?>$`)
+)
+
+// parseSlackIDs parses a comma separated list of SlackIDs.
+func parseSlackIDs(list string) map[string]bool {
+	ids := make(map[string]bool)
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// userRole returns the most privileged role of the user.
+func userRole(user *User) string {
+	role := RoleUser
+	if roleRanks[user.Role] > roleRanks[role] {
+		role = user.Role
+	}
+	if moderatorSlackIDs[user.SlackID] && roleRanks[RoleModerator] > roleRanks[role] {
+		role = RoleModerator
+	}
+	if adminSlackIDs[user.SlackID] {
+		role = RoleAdmin
+	}
+	return role
+}
+
+// hasRole returns true if the user has the role or a more privileged one.
+func hasRole(user *User, role string) bool {
+	return roleRanks[userRole(user)] >= roleRanks[role]
+}
+
+// requireRole wraps a command so that only the users with the role can run it.
+func requireRole(role string, cmd func(*SlackCommandRequest, *User) *SlackCommandResponse) func(*SlackCommandRequest, *User) *SlackCommandResponse {
+	return func(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+		if !hasRole(user, role) {
+			cmdStr := strings.SplitN(req.Text, " ", 2)[0]
+			return &SlackCommandResponse{Text: fmt.Sprintf("Error: You're not allowed to run %q, it's for the %ss.", cmdStr, role)}
+		}
+		return cmd(req, user)
+	}
+}
+
+func slackCommandTVNext(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	if _, err := scheduler.RotateNow(); err != nil {
+		return &SlackCommandResponse{Text: fmt.Sprintf("Error: Can't start the next question: %v", err)}
+	}
+	return &SlackCommandResponse{Text: "Next question started."}
+}
+
+func slackCommandTVSkip(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	if _, err := scheduler.Skip(); err != nil {
+		return &SlackCommandResponse{Text: fmt.Sprintf("Error: Can't skip the question: %v", err)}
+	}
+	return &SlackCommandResponse{Text: "Question skipped."}
+}
+
+// slackCommandTVSchedule schedules a question, e.g. "schedule 12 2017-03-01 14:30".
+func slackCommandTVSchedule(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	resp := &SlackCommandResponse{}
+	args := strings.SplitN(req.Text, " ", 3)
+	if len(args) != 3 {
+		resp.Text = commandTVUsage
+		return resp
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		resp.Text = "Error: Invalid question id"
+		return resp
+	}
+	at, err := parseScheduleTime(args[2])
+	if err != nil {
+		resp.Text = "Error: Invalid time, e.g. 2017-03-01 14:30"
+		return resp
+	}
+	question, err := scheduler.Schedule(uint(id), at)
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't schedule question: %v", err)
+		return resp
+	}
+	resp.Text = fmt.Sprintf("Question #%d scheduled for %s.", question.ID, question.ScheduledAt.In(questionLocation).Format(scheduleTimeLayout))
+	return resp
+}
+
+// parseScheduleTime parses a time of the questions timezone in the scheduleTimeLayout, or an RFC 3339 time.
+func parseScheduleTime(value string) (time.Time, error) {
+	if at, err := time.ParseInLocation(scheduleTimeLayout, value, questionLocation); err == nil {
+		return at, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// slackCommandTVDeleteImage deletes the image with the id, or the last one.
+func slackCommandTVDeleteImage(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	resp := &SlackCommandResponse{}
+	var image *Image
+	if args := strings.Fields(req.Text); len(args) > 1 {
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil || id == 0 {
+			resp.Text = "Error: Invalid image id"
+			return resp
+		}
+		if image, err = db.GetImage(uint(id)); err == gorm.ErrRecordNotFound {
+			resp.Text = "Error: No such image"
+			return resp
+		} else if err != nil {
+			resp.Text = fmt.Sprintf("Error: Can't get image: %v", err)
+			return resp
+		}
+	} else {
+		var err error
+		if image, err = GetLastImage(); err != nil {
+			resp.Text = "Error: No image to delete"
+			return resp
+		}
+	}
+	if err := db.DeleteImage(image.ID); err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't delete image: %v", err)
+		return resp
+	}
+	events.Publish(EventImageDeleted, image)
+	resp.Text = fmt.Sprintf("Image %d deleted.", image.ID)
+	return resp
+}
+
+func slackCommandTVResetPoints(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	if err := resetPoints(db); err != nil {
+		return &SlackCommandResponse{Text: fmt.Sprintf("Error: Can't reset points: %v", err)}
+	}
+	publishLeaderboard()
+	return &SlackCommandResponse{Text: "Points reset."}
+}
+
+// slackCommandTVRole gives a role to a user, e.g. "role @john moderator".
+func slackCommandTVRole(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	resp := &SlackCommandResponse{}
+	args := strings.Fields(req.Text)
+	if len(args) != 3 {
+		resp.Text = commandTVUsage
+		return resp
+	}
+	slackID := args[1]
+	if match := slackMentionRegexp.FindStringSubmatch(slackID); match != nil {
+		slackID = match[1]
+	}
+	role := args[2]
+	if _, ok := roleRanks[role]; !ok {
+		resp.Text = fmt.Sprintf("Error: Invalid role %q: user, moderator or admin", role)
+		return resp
+	}
+	target, err := GetUserBySlackID(slackID)
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't get user: %v", err)
+		return resp
+	}
+	if err := db.SetUserRole(target.ID, role); err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't set role: %v", err)
+		return resp
+	}
+	resp.Text = fmt.Sprintf("%s %s is now %s.", target.FirstName, target.LastName, role)
+	return resp
+}
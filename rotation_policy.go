@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policies picking the next random question.
+const (
+	// PolicyRandom picks any question.
+	PolicyRandom = "random"
+	// PolicyRoundRobin picks a question of the category following the one of the last question.
+	PolicyRoundRobin = "round-robin"
+	// PolicyThemed picks a question with the weight of its category on the day.
+	PolicyThemed = "themed"
+)
+
+// questionPolicy is the rotation policy, set by loadConfig.
+var questionPolicy *rotationPolicy
+
+// rotationPolicy picks the next question among the unstarted ones.
+// themes gives the weights of the categories by day for PolicyThemed, 1 by default.
+type rotationPolicy struct {
+	name     string
+	themes   map[time.Weekday]map[string]int
+	location *time.Location
+}
+
+// rotationPolicyFromEnv returns the policy set by QUESTION_ROTATION_POLICY and QUESTION_THEMES,
+// with the days in the location. The questions are picked at random by default.
+func rotationPolicyFromEnv(location *time.Location) (*rotationPolicy, error) {
+	return newRotationPolicy(os.Getenv("QUESTION_ROTATION_POLICY"), os.Getenv("QUESTION_THEMES"), location)
+}
+
+// newRotationPolicy parses a policy name and the themes, e.g. "friday:geography=5,history=2;monday:music=0",
+// of the days in the location.
+func newRotationPolicy(name, themes string, location *time.Location) (*rotationPolicy, error) {
+	p := &rotationPolicy{name: name, themes: make(map[time.Weekday]map[string]int), location: location}
+	switch name {
+	case "":
+		p.name = PolicyRandom
+	case PolicyRandom, PolicyRoundRobin, PolicyThemed:
+	default:
+		return nil, fmt.Errorf("Invalid rotation policy %q, must be random, round-robin or themed", name)
+	}
+	for _, theme := range strings.Split(themes, ";") {
+		if theme = strings.TrimSpace(theme); theme == "" {
+			continue
+		}
+		parts := strings.SplitN(theme, ":", 2)
+		day, ok := parseWeekday(parts[0])
+		if !ok || len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid theme %q, e.g. friday:geography=5,history=2", theme)
+		}
+		weights := make(map[string]int)
+		for _, weight := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(weight, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("Invalid theme weight %q, e.g. geography=5", weight)
+			}
+			w, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("Invalid theme weight %q, e.g. geography=5", weight)
+			}
+			weights[normalizeCategory(kv[0])] = w
+		}
+		p.themes[day] = weights
+	}
+	return p, nil
+}
+
+// parseWeekday parses a day name, e.g. "friday" or "fri".
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || (len(name) >= 3 && strings.HasPrefix(full, name)) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// Pick returns one of the questions. last is the category of the last question.
+func (p *rotationPolicy) Pick(questions []Question, last string, now time.Time) *Question {
+	switch p.name {
+	case PolicyRoundRobin:
+		questions = nextCategoryQuestions(questions, last)
+	case PolicyThemed:
+		if question := p.pickWeighted(questions, now); question != nil {
+			return question
+		}
+	}
+	return &questions[rand.Intn(len(questions))]
+}
+
+// pickWeighted picks a question with the weight of its category on the day of now.
+// It returns nil if every weight is 0.
+func (p *rotationPolicy) pickWeighted(questions []Question, now time.Time) *Question {
+	weights := p.themes[now.In(p.location).Weekday()]
+	weight := func(question *Question) int {
+		if w, ok := weights[question.Category]; ok {
+			return w
+		}
+		return 1
+	}
+	total := 0
+	for i := range questions {
+		total += weight(&questions[i])
+	}
+	if total == 0 {
+		return nil
+	}
+	n := rand.Intn(total)
+	for i := range questions {
+		if n -= weight(&questions[i]); n < 0 {
+			return &questions[i]
+		}
+	}
+	return nil
+}
+
+// nextCategoryQuestions returns the questions of the first category after last,
+// in alphabetical order and wrapping around.
+func nextCategoryQuestions(questions []Question, last string) []Question {
+	byCategory := make(map[string][]Question)
+	var categories []string
+	for _, question := range questions {
+		if _, ok := byCategory[question.Category]; !ok {
+			categories = append(categories, question.Category)
+		}
+		byCategory[question.Category] = append(byCategory[question.Category], question)
+	}
+	sort.Strings(categories)
+	next := categories[0]
+	for _, category := range categories {
+		if category > last {
+			next = category
+			break
+		}
+	}
+	return byCategory[next]
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+// RotationState tells whether the questions are rotating, and when the next one starts.
+type RotationState struct {
+	OffHours     bool
+	NextRotation time.Time
+}
+
+// rotationSchedule gives the times of the question rotations: the times of a cron
+// schedule in a timezone, except the ones within the quiet hours.
+type rotationSchedule struct {
+	cron     cron.Schedule
+	location *time.Location
+	quiet    []quietHours
+}
+
+// quietHours is a daily period without rotations, in minutes since midnight.
+// It ends the next day when end is before start.
+type quietHours struct {
+	start, end int
+}
+
+// rotationScheduleFromEnv returns the schedule set by QUESTION_SCHEDULE and QUESTION_QUIET_HOURS
+// in the location. Without QUESTION_SCHEDULE, the questions rotate every QUESTION_REFRESH_RATE.
+func rotationScheduleFromEnv(location *time.Location) (*rotationSchedule, error) {
+	spec := os.Getenv("QUESTION_SCHEDULE")
+	if spec == "" {
+		rate, err := envDuration("QUESTION_REFRESH_RATE", time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		spec = "@every " + rate.String()
+	}
+	return newRotationSchedule(spec, location, os.Getenv("QUESTION_QUIET_HOURS"))
+}
+
+// newRotationSchedule parses a 5 fields cron spec, e.g. "0 9-18 * * 1-5",
+// and quiet hours, e.g. "12:00-14:00,19:00-08:00", in the location.
+func newRotationSchedule(spec string, location *time.Location, quiet string) (*rotationSchedule, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schedule %q: %v", spec, err)
+	}
+	s := &rotationSchedule{cron: schedule, location: location}
+	for _, period := range strings.Split(quiet, ",") {
+		if period = strings.TrimSpace(period); period == "" {
+			continue
+		}
+		var q quietHours
+		var startHour, startMin, endHour, endMin int
+		_, err := fmt.Sscanf(period, "%d:%d-%d:%d", &startHour, &startMin, &endHour, &endMin)
+		if err != nil || !validClock(startHour, startMin) || !validClock(endHour, endMin) {
+			return nil, fmt.Errorf("Invalid quiet hours %q, e.g. 19:00-08:00", period)
+		}
+		q.start, q.end = startHour*60+startMin, endHour*60+endMin
+		s.quiet = append(s.quiet, q)
+	}
+	return s, nil
+}
+
+// validClock returns true if hour:min is a valid time of the day.
+func validClock(hour, min int) bool {
+	return hour >= 0 && hour < 24 && min >= 0 && min < 60
+}
+
+// Next returns the first rotation after t.
+func (s *rotationSchedule) Next(t time.Time) time.Time {
+	next := s.cron.Next(t.In(s.location))
+	// Every iteration jumps over a quiet period, so a few are enough.
+	for i := 0; i < 16; i++ {
+		end, quiet := s.quietEnd(next)
+		if !quiet {
+			return next
+		}
+		next = s.cron.Next(end.Add(-time.Second))
+	}
+	return next
+}
+
+// OffHours returns true if the rotation is paused at t: during the quiet hours,
+// or when there was no rotation and there is none for longer than the usual interval,
+// e.g. at night for "0 9-18 * * 1-5".
+func (s *rotationSchedule) OffHours(t time.Time) bool {
+	if _, quiet := s.quietEnd(t); quiet {
+		return true
+	}
+	t = t.In(s.location)
+	next := s.cron.Next(t)
+	interval := s.cron.Next(next).Sub(next)
+	return next.Sub(t) > interval && s.cron.Next(t.Add(-interval)).After(t)
+}
+
+// quietEnd returns the end of the quiet period t is within, if any.
+func (s *rotationSchedule) quietEnd(t time.Time) (time.Time, bool) {
+	t = t.In(s.location)
+	minutes := t.Hour()*60 + t.Minute()
+	at := func(day, minutes int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+day, 0, minutes, 0, 0, s.location)
+	}
+	for _, q := range s.quiet {
+		switch {
+		case q.start <= q.end && minutes >= q.start && minutes < q.end:
+			return at(0, q.end), true
+		case q.start > q.end && minutes >= q.start:
+			return at(1, q.end), true
+		case q.start > q.end && minutes < q.end:
+			return at(0, q.end), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// getRotationState returns whether the questions are rotating.
+func getRotationState(w http.ResponseWriter, r *http.Request) {
+	renderJSON(w, http.StatusOK, scheduler.State())
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// scheduler rotates the questions, set by loadConfig.
+	scheduler *questionScheduler
+
+	// schedulerStatePeriod is how often the scheduler checks if the rotation is paused.
+	schedulerStatePeriod = time.Minute
+
+	errScheduleInPast = errors.New("Schedule time is in the past")
+)
+
+// questionScheduler rotates the questions following the schedule, and starts the
+// scheduled questions at their time. It wakes for whichever comes first.
+type questionScheduler struct {
+	mu           sync.Mutex
+	schedule     *rotationSchedule
+	nextRotation time.Time
+	offHours     bool
+	wake         chan struct{}
+	// rotating serializes the rotations.
+	rotating sync.Mutex
+}
+
+func newQuestionScheduler(schedule *rotationSchedule) *questionScheduler {
+	now := time.Now()
+	return &questionScheduler{
+		schedule:     schedule,
+		nextRotation: schedule.Next(now),
+		offHours:     schedule.OffHours(now),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Run rotates the questions forever.
+func (s *questionScheduler) Run() {
+	s.rotated(time.Now())
+	for {
+		wait := time.Until(s.nextWake())
+		if wait > schedulerStatePeriod {
+			wait = schedulerStatePeriod
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			s.tick(time.Now())
+		case <-s.wake:
+			timer.Stop()
+		}
+		s.checkState(time.Now())
+		checkSeason(time.Now())
+	}
+}
+
+// State returns whether the questions are rotating, and when the next one starts.
+func (s *questionScheduler) State() RotationState {
+	s.mu.Lock()
+	offHours := s.offHours
+	s.mu.Unlock()
+	return RotationState{OffHours: offHours, NextRotation: s.nextWake()}
+}
+
+// RotateNow starts a random question, and returns it.
+func (s *questionScheduler) RotateNow() (*Question, error) {
+	return s.rotate(0, true)
+}
+
+// Skip starts a random question without scoring the current one, and returns it.
+func (s *questionScheduler) Skip() (*Question, error) {
+	return s.rotate(0, false)
+}
+
+// Schedule starts the question at the time instead of a random one.
+func (s *questionScheduler) Schedule(id uint, at time.Time) (*Question, error) {
+	if !at.After(time.Now()) {
+		return nil, errScheduleInPast
+	}
+	question, err := getStartableQuestion(db, id)
+	if err != nil {
+		return nil, err
+	}
+	question.ScheduledAt = at
+	if err := db.SaveQuestion(question); err != nil {
+		return nil, err
+	}
+	s.notify()
+	return question, nil
+}
+
+// tick starts the scheduled question due at now, if any,
+// or a random question if the interval is over.
+func (s *questionScheduler) tick(now time.Time) {
+	if scheduled, err := getNextScheduledQuestion(db); err == nil && !scheduled.ScheduledAt.After(now) {
+		if _, err := s.rotate(scheduled.ID, true); err == errRevealInProgress {
+			return
+		} else if err != nil {
+			log.WithFields(log.Fields{
+				"question_id": scheduled.ID,
+				"err":         err,
+			}).Error("Can't start scheduled question")
+			// Don't try again on every tick.
+			scheduled.ScheduledAt = time.Time{}
+			if err := db.SaveQuestion(scheduled); err != nil {
+				log.WithFields(log.Fields{
+					"question_id": scheduled.ID,
+					"err":         err,
+				}).Error("Can't unschedule question")
+			}
+		}
+		return
+	}
+	s.mu.Lock()
+	due := !s.nextRotation.After(now)
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+	if _, err := s.rotate(0, true); err == errRevealInProgress {
+		return
+	} else if err != nil {
+		log.WithField("err", err).Error("Can't set nextQuestion")
+		s.rotated(now)
+	}
+}
+
+// checkState publishes the pauses and resumes of the rotation.
+func (s *questionScheduler) checkState(now time.Time) {
+	offHours := s.schedule.OffHours(now)
+	s.mu.Lock()
+	changed := offHours != s.offHours
+	s.offHours = offHours
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	if offHours {
+		events.Publish(EventRotationPaused, s.State())
+	} else {
+		events.Publish(EventRotationResumed, s.State())
+	}
+}
+
+// rotate rotates the question, computes the next rotation, and returns the next question.
+func (s *questionScheduler) rotate(id uint, score bool) (*Question, error) {
+	s.rotating.Lock()
+	defer s.rotating.Unlock()
+	next, err := rotateQuestion(id, score)
+	if err != nil {
+		return nil, err
+	}
+	s.rotated(time.Now())
+	return next, nil
+}
+
+// rotated computes the next rotation after now.
+func (s *questionScheduler) rotated(now time.Time) {
+	s.mu.Lock()
+	s.nextRotation = s.schedule.Next(now)
+	s.mu.Unlock()
+	s.notify()
+}
+
+// nextWake returns the time of the next rotation or scheduled question,
+// which waits for the end of the reveal of the current question.
+func (s *questionScheduler) nextWake() time.Time {
+	s.mu.Lock()
+	wake := s.nextRotation
+	s.mu.Unlock()
+	if scheduled, err := getNextScheduledQuestion(db); err == nil && scheduled.ScheduledAt.Before(wake) {
+		wake = scheduled.ScheduledAt
+	}
+	if current, err := db.GetCurrentQuestion(); err == nil && !current.EndedAt.IsZero() {
+		if end := current.EndedAt.Add(revealDuration); end.After(wake) {
+			wake = end
+		}
+	}
+	return wake
+}
+
+// notify wakes the scheduler up to compute its next wake time.
+func (s *questionScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// getNextScheduledQuestion returns the scheduled question with the earliest time.
+func getNextScheduledQuestion(tx Store) (*Question, error) {
+	questions, err := tx.GetUnstartedQuestions()
+	if err != nil {
+		return nil, err
+	}
+	var next *Question
+	for i, question := range questions {
+		if question.ScheduledAt.IsZero() {
+			continue
+		}
+		if next == nil || question.ScheduledAt.Before(next.ScheduledAt) {
+			next = &questions[i]
+		}
+	}
+	if next == nil {
+		return nil, errNoQuestionAvailable
+	}
+	return next, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Reasons of the point events.
+const (
+	ReasonRightAnswer = "right_answer"
+	ReasonSpeed       = "speed"
+	ReasonDifficulty  = "difficulty"
+	ReasonStreak      = "streak"
+)
+
+var (
+	// scoreBase is the points of a right answer. The scoring settings below are
+	// the defaults, overridden from the env by loadConfig.
+	scoreBase = 10
+	// scoreSpeedBonus is the bonus of an immediate answer, decreasing to 0 at scoreSpeedWindow.
+	scoreSpeedBonus  = 5
+	scoreSpeedWindow = 10 * time.Minute
+	// scoreDifficultyFactor multiplies the base points when nobody else got it right,
+	// e.g. 1 doubles them, and proportionally to the share of wrong answers.
+	scoreDifficultyFactor = 1
+	// scoreStreakBonus is the bonus of each previous right answer in a row, up to scoreStreakMax.
+	scoreStreakBonus = 2
+	scoreStreakMax   = 5
+
+	// scoreRules are applied in order to every right answer, each award being a point event.
+	scoreRules = []scoreRule{
+		{ReasonRightAnswer, func(c *scoreContext) int { return scoreBase }},
+		{ReasonSpeed, speedBonus},
+		{ReasonDifficulty, difficultyBonus},
+		{ReasonStreak, streakBonus},
+	}
+)
+
+// PointEvent is an award of points to a user, for an answer to a question.
+// The points of a user are the sum of its events. Note tells why the points
+// of an adjustment were given or taken back.
+type PointEvent struct {
+	gorm.Model
+	UserID     uint
+	QuestionID uint
+	Points     int
+	Reason     string
+	Note       string
+}
+
+// scoreRule awards points to a right answer.
+type scoreRule struct {
+	reason string
+	points func(c *scoreContext) int
+}
+
+// scoreContext contains what the rules know about a right answer.
+// Streak counts the right answers of the user in a row, this one included.
+type scoreContext struct {
+	Question *Question
+	Entry    *AnswerEntry
+	Total    int
+	Right    int
+	Streak   uint
+}
+
+// speedBonus decreases linearly from the start of the question. The last change of the answer
+// is used, so that changing it doesn't keep the bonus of the first one.
+func speedBonus(c *scoreContext) int {
+	elapsed := c.Entry.UpdatedAt.Sub(c.Question.StartedAt)
+	if scoreSpeedWindow <= 0 || elapsed >= scoreSpeedWindow {
+		return 0
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return int(math.Round(float64(scoreSpeedBonus) * float64(scoreSpeedWindow-elapsed) / float64(scoreSpeedWindow)))
+}
+
+// difficultyBonus grows with the share of the users who got it wrong.
+func difficultyBonus(c *scoreContext) int {
+	if c.Total <= 1 {
+		return 0
+	}
+	wrong := float64(c.Total-c.Right) / float64(c.Total-1)
+	return int(math.Round(float64(scoreBase*scoreDifficultyFactor) * wrong))
+}
+
+// streakBonus rewards the previous right answers in a row.
+func streakBonus(c *scoreContext) int {
+	previous := int(c.Streak) - 1
+	if previous > scoreStreakMax {
+		previous = scoreStreakMax
+	}
+	return scoreStreakBonus * previous
+}
+
+// scoreQuestion awards the points of the right answers to the question,
+// and updates the streaks of the users who answered.
+func scoreQuestion(tx Store, question *Question) error {
+	entries, err := tx.GetAnswerEntriesByQuestionID(question.ID)
+	if err != nil {
+		return err
+	}
+	right := 0
+	for _, entry := range entries {
+		if entry.AnswerID == question.RightAnswerID {
+			right++
+		}
+	}
+	for i := range entries {
+		entry := &entries[i]
+		user, err := tx.GetUser(entry.UserID)
+		if err != nil {
+			return err
+		}
+		if entry.AnswerID != question.RightAnswerID {
+			if err := tx.SetUserStreak(user.ID, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		c := &scoreContext{Question: question, Entry: entry, Total: len(entries), Right: right, Streak: user.Streak + 1}
+		for _, rule := range scoreRules {
+			points := rule.points(c)
+			if points <= 0 {
+				continue
+			}
+			event := &PointEvent{UserID: user.ID, QuestionID: question.ID, Points: points, Reason: rule.reason}
+			if err := tx.AddPointEvent(event); err != nil {
+				return err
+			}
+		}
+		if err := tx.SetUserStreak(user.ID, c.Streak); err != nil {
+			return err
+		}
+	}
+	return nil
+}
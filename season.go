@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/jinzhu/gorm"
+)
+
+// podiumSize is the number of standings of the past seasons listed.
+const podiumSize = 3
+
+var (
+	// seasonPeriod is the period of the seasons, day, week or month, set by loadConfig.
+	// The seasons are only rolled over by the admins when it's empty.
+	seasonPeriod string
+
+	errInvalidSeasonID = Error{"Invalid season_id"}
+	errSeasonNotFound  = errors.New("Season not found")
+)
+
+// Season is a run of the quiz. The current season isn't ended.
+type Season struct {
+	gorm.Model
+	Name      string
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+// SeasonStanding is the final rank of a user in a season, Position starting from 1.
+type SeasonStanding struct {
+	gorm.Model
+	SeasonID uint
+	UserID   uint
+	Position int
+	Points   uint
+}
+
+// SeasonDetail is a season with its standings. The standings of the current season are the ones of now.
+type SeasonDetail struct {
+	Season    *Season
+	Standings []Standing
+}
+
+// RolloverSeasonRequest contains the data of rollover season request.
+type RolloverSeasonRequest struct {
+	Name string `schema:"name"`
+}
+
+// seasonPeriodFromEnv returns the period set by SEASON_PERIOD.
+func seasonPeriodFromEnv() (string, error) {
+	period := os.Getenv("SEASON_PERIOD")
+	switch period {
+	case "", PeriodDay, PeriodWeek, PeriodMonth:
+		return period, nil
+	}
+	return "", fmt.Errorf("Invalid season period %q, must be day, week or month", period)
+}
+
+// seasonName returns the name of the season of the period starting at start, e.g. 2017-03.
+func seasonName(period string, start time.Time) string {
+	switch period {
+	case PeriodMonth:
+		return start.Format("2006-01")
+	case PeriodWeek:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return start.Format("2006-01-02")
+}
+
+// checkSeason rolls the season over when its period is over.
+func checkSeason(now time.Time) {
+	if seasonPeriod == "" {
+		return
+	}
+	start, _ := periodStart(seasonPeriod, now)
+	current, err := db.GetCurrentSeason()
+	if err == nil && !current.StartedAt.Before(start) {
+		return
+	} else if err != nil && err != gorm.ErrRecordNotFound {
+		log.WithField("err", err).Error("Can't get current season")
+		return
+	}
+	season, err := rolloverSeason(db, seasonName(seasonPeriod, start), start)
+	if err != nil {
+		log.WithField("err", err).Error("Can't roll season over")
+		return
+	}
+	log.WithField("season", season.Name).Info("Season started")
+}
+
+// rolloverSeason ends the current season at start, archives its standings, and starts
+// a new season where everyone starts from zero. users.points stay the lifetime points:
+// the season totals are the point events from its StartedAt, so every leaderboard of the
+// season is computed from the ledger, see GetSeasonLeaderboard. The streaks are reset.
+func rolloverSeason(s Store, name string, start time.Time) (*Season, error) {
+	season := &Season{Name: name, StartedAt: start}
+	err := s.Transaction(func(tx Store) error {
+		current, err := tx.GetCurrentSeason()
+		if err == nil {
+			if err := endSeason(tx, current, start); err != nil {
+				return err
+			}
+		} else if err != gorm.ErrRecordNotFound {
+			return err
+		}
+		users, err := tx.GetUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if user.Streak == 0 {
+				continue
+			}
+			if err := tx.SetUserStreak(user.ID, 0); err != nil {
+				return err
+			}
+		}
+		return tx.CreateSeason(season)
+	})
+	if err != nil {
+		return nil, err
+	}
+	publishLeaderboard()
+	return season, nil
+}
+
+// endSeason ends the season at end and archives its standings.
+func endSeason(tx Store, season *Season, end time.Time) error {
+	if end.Before(season.StartedAt) {
+		end = season.StartedAt
+	}
+	standings, err := GetLeaderboard(tx, season.StartedAt, end, 0)
+	if err != nil {
+		return err
+	}
+	for _, standing := range standings {
+		archived := &SeasonStanding{SeasonID: season.ID, UserID: standing.ID, Position: standing.Rank, Points: standing.Points}
+		if err := tx.CreateSeasonStanding(archived); err != nil {
+			return err
+		}
+	}
+	season.EndedAt = end
+	return tx.SaveSeason(season)
+}
+
+// getSeasons returns the past seasons with their podium, the last first.
+func getSeasons(w http.ResponseWriter, r *http.Request) {
+	seasons, err := db.GetPastSeasons()
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	details := make([]SeasonDetail, 0, len(seasons))
+	for i := range seasons {
+		detail, err := newSeasonDetail(db, &seasons[i], podiumSize)
+		if err != nil {
+			renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+		details = append(details, *detail)
+	}
+	renderJSON(w, http.StatusOK, details)
+}
+
+// getCurrentSeason returns the current season with its standings.
+func getCurrentSeason(w http.ResponseWriter, r *http.Request) {
+	season, err := db.GetCurrentSeason()
+	if err != nil {
+		renderJSON(w, http.StatusNotFound, Error{errSeasonNotFound.Error()})
+		return
+	}
+	renderSeason(w, season)
+}
+
+// getSeason returns a season with its standings.
+func getSeason(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	id, err := strconv.ParseUint(params["season_id"], 10, 64)
+	if err != nil {
+		renderJSON(w, http.StatusBadRequest, errInvalidSeasonID)
+		return
+	}
+	season, err := db.GetSeason(uint(id))
+	if err != nil {
+		renderJSON(w, http.StatusNotFound, Error{errSeasonNotFound.Error()})
+		return
+	}
+	renderSeason(w, season)
+}
+
+// rolloverSeasonNow ends the current season and starts a new one, named after the day by default.
+func rolloverSeasonNow(w http.ResponseWriter, r *http.Request) {
+	var req RolloverSeasonRequest
+	if err := decodeRequestForm(r, &req); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	now := time.Now()
+	if req.Name == "" {
+		req.Name = now.In(questionLocation).Format("2006-01-02")
+	}
+	season, err := rolloverSeason(db, req.Name, now)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	renderSeason(w, season)
+}
+
+func renderSeason(w http.ResponseWriter, season *Season) {
+	detail, err := newSeasonDetail(db, season, 0)
+	if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	renderJSON(w, http.StatusOK, detail)
+}
+
+// newSeasonDetail returns the season with its count first standings, or all of them if count is 0.
+func newSeasonDetail(tx Store, season *Season, count int) (*SeasonDetail, error) {
+	detail := &SeasonDetail{Season: season}
+	var err error
+	if season.EndedAt.IsZero() {
+		detail.Standings, err = GetLeaderboard(tx, season.StartedAt, time.Time{}, count)
+		return detail, err
+	}
+	archived, err := tx.GetSeasonStandings(season.ID, count)
+	if err != nil {
+		return nil, err
+	}
+	detail.Standings = make([]Standing, 0, len(archived))
+	for _, standing := range archived {
+		user, err := tx.GetUser(standing.UserID)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		user.ID = standing.UserID
+		user.Points = standing.Points
+		detail.Standings = append(detail.Standings, Standing{User: *user, Rank: standing.Position})
+	}
+	return detail, nil
+}
@@ -2,15 +2,14 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -19,13 +18,28 @@ var (
 	slackOutgoingToken = os.Getenv("SLACK_OUTGOING_TOKEN")
 	slackURL           = "https://slack.com"
 
-	commandTVUsage = "Valid commands: help, question, answer, image, status."
+	commandTVUsage = "Valid commands: help, question, answer, quiz, image, status.\nModerators: pending, approve <id>, reject <id> <reason>.\nAdmins: next, skip, schedule <id> <YYYY-MM-DD HH:MM>, delete-image [id], reset-points, adjust-points <user> <points> <reason>, recompute-points, role <user> <user|moderator|admin>, team <user> <team|none>, team-sync [<team> <usergroup>]."
 	commandTVFunc  = map[string]func(*SlackCommandRequest, *User) *SlackCommandResponse{
 		"help":     slackCommandTVHelp,
 		"question": slackCommandTVQuestion,
 		"answer":   slackCommandTVAnswer,
+		"quiz":     slackCommandTVQuiz,
 		"status":   slackCommandTVStatus,
 		"image":    slackCommandTVImage,
+		"pending":  requireRole(RoleModerator, slackCommandTVPending),
+		"approve":  requireRole(RoleModerator, slackCommandTVApprove),
+		"reject":   requireRole(RoleModerator, slackCommandTVReject),
+
+		"next":             requireRole(RoleAdmin, slackCommandTVNext),
+		"skip":             requireRole(RoleAdmin, slackCommandTVSkip),
+		"schedule":         requireRole(RoleAdmin, slackCommandTVSchedule),
+		"delete-image":     requireRole(RoleAdmin, slackCommandTVDeleteImage),
+		"reset-points":     requireRole(RoleAdmin, slackCommandTVResetPoints),
+		"adjust-points":    requireRole(RoleAdmin, slackCommandTVAdjustPoints),
+		"recompute-points": requireRole(RoleAdmin, slackCommandTVRecomputePoints),
+		"team":             requireRole(RoleAdmin, slackCommandTVTeam),
+		"team-sync":        requireRole(RoleAdmin, slackCommandTVTeamSync),
+		"role":             requireRole(RoleAdmin, slackCommandTVRole),
 	}
 
 	argsRegexp = regexp.MustCompile("'.+'|\".+\"|\\S+")
@@ -41,8 +55,12 @@ type SlackCommandRequest struct {
 }
 
 // SlackCommandResponse contains the data of slack command request.
+// It is also used to reply to the interactions.
 type SlackCommandResponse struct {
-	Text string `json:"text"`
+	ResponseType    string       `json:"response_type,omitempty"`
+	ReplaceOriginal bool         `json:"replace_original,omitempty"`
+	Text            string       `json:"text"`
+	Blocks          []SlackBlock `json:"blocks,omitempty"`
 }
 
 func slackCommandTV(w http.ResponseWriter, r *http.Request) {
@@ -51,29 +69,19 @@ func slackCommandTV(w http.ResponseWriter, r *http.Request) {
 		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
 		return
 	}
-	if req.Token != slackCommandToken {
-		renderJSON(w, http.StatusBadRequest, errInvalidToken)
-		return
-	}
 	user, err := GetUserBySlackID(req.UserID)
 	if err != nil {
 		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
 		return
 	}
-	resp, err := getCommandTVResponse(&req, user)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
+	if err := slackClient.Respond(req.ResponseURL, getCommandTVResponse(&req, user)); err != nil {
+		renderJSON(w, http.StatusBadGateway, Error{err.Error()})
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func getCommandTVResponse(req *SlackCommandRequest, user *User) (*http.Response, error) {
+func getCommandTVResponse(req *SlackCommandRequest, user *User) *SlackCommandResponse {
 	cmdStr := req.Text
 	if i := strings.IndexRune(req.Text, ' '); i != -1 {
 		cmdStr = cmdStr[:i]
@@ -85,11 +93,7 @@ func getCommandTVResponse(req *SlackCommandRequest, user *User) (*http.Response,
 		text := fmt.Sprintf("Invalid command %q.\n%s", cmdStr, commandTVUsage)
 		slackResp = &SlackCommandResponse{Text: text}
 	}
-	body := &bytes.Buffer{}
-	if err := json.NewEncoder(body).Encode(slackResp); err != nil {
-		return nil, err
-	}
-	return http.Post(req.ResponseURL, ContentJSON, body)
+	return slackResp
 }
 
 func slackCommandTVHelp(req *SlackCommandRequest, user *User) *SlackCommandResponse {
@@ -103,54 +107,37 @@ func slackCommandTVQuestion(req *SlackCommandRequest, user *User) *SlackCommandR
 		return resp
 	}
 	argsStr := req.Text[len("question "):]
-	args := argsRegexp.FindAllString(argsStr, -1)
-	if len(args) < 4 || len(args) > 6 {
-		nbrAnswers := len(args) - 2
-		if nbrAnswers < 0 {
-			nbrAnswers = 0
-		}
-		resp.Text = fmt.Sprintf("Error: Can't set %d answers: Minimum 2 answers and maximum 4 answers", nbrAnswers)
-		return resp
-	}
-	if len(args[0]) > 128 {
-		resp.Text = "Error: Question is too long maximum 128 characters"
-		return resp
-	}
-	answerIndex, _ := strconv.Atoi(args[1])
-	answersStr := args[2:]
-	if answerIndex <= 0 || answerIndex > len(answersStr) {
-		resp.Text = "Error: Invalid right answer index"
-		return resp
-	}
-	for i, answerStr := range answersStr {
-		if len(answerStr) > 32 {
-			resp.Text = fmt.Sprintf("Error: Answer %d is too long maximum 128 characters", i+1)
-			return resp
-		}
-	}
-	tx := db.Begin()
-	question := &Question{UserID: user.ID, Sentence: args[0]}
-	if err := tx.Create(question).Error; err != nil {
-		tx.Rollback()
-		resp.Text = fmt.Sprintf("Error: Can't create question: %s", err)
-		return resp
-	}
-	for i, answerStr := range answersStr {
-		answer := &Answer{QuestionID: question.ID, Sentence: answerStr}
-		if err := tx.Create(answer).Error; err != nil {
-			tx.Rollback()
-			resp.Text = fmt.Sprintf("Error: Can't create answer: %s", err)
-			return resp
-		}
-		if i == answerIndex-1 {
-			if err := tx.Model(&question).UpdateColumn("right_answer_id", answer.ID).Error; err != nil {
-				tx.Rollback()
-				resp.Text = fmt.Sprintf("Error: Can't update question right_answer_id: %s", err)
-				return resp
-			}
-		}
-	}
-	tx.Commit()
+	args, category, tags, err := parseQuestionFlags(argsRegexp.FindAllString(argsStr, -1))
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: %s", err)
+		return resp
+	}
+	if err := validateCategory(category, tags); err != nil {
+		resp.Text = fmt.Sprintf("Error: %s", err)
+		return resp
+	}
+	var sentence string
+	var answerIndex int
+	var answersStr []string
+	if len(args) >= 2 {
+		sentence, answersStr = args[0], args[2:]
+		answerIndex, _ = strconv.Atoi(args[1])
+	}
+	if err := validateQuestion(sentence, answersStr, answerIndex); err != nil {
+		resp.Text = fmt.Sprintf("Error: %s", err)
+		return resp
+	}
+	question := &Question{UserID: user.ID, Sentence: sentence, Category: category, Tags: tags, Status: QuestionPending}
+	if hasRole(user, RoleModerator) {
+		question.Status = QuestionApproved
+	}
+	err = db.Transaction(func(tx Store) error {
+		return createQuestion(tx, question, answersStr, answerIndex)
+	})
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: %s", err)
+		return resp
+	}
 	resp.Text = "Your question has been submitted. Thank You!"
 	return resp
 }
@@ -162,6 +149,10 @@ func slackCommandTVAnswer(req *SlackCommandRequest, user *User) *SlackCommandRes
 		resp.Text = fmt.Sprintf("Error: Can't get current question: %v", err)
 		return resp
 	}
+	if !question.EndedAt.IsZero() {
+		resp.Text = errQuestionOver.Error()
+		return resp
+	}
 	answers, err := GetAnswersByQuestionID(question.ID)
 	if err != nil {
 		resp.Text = fmt.Sprintf("Error: Can't get answers: %v", err)
@@ -178,10 +169,11 @@ func slackCommandTVAnswer(req *SlackCommandRequest, user *User) *SlackCommandRes
 	}
 	answer := answers[answerIndex-1]
 	answerEntry := &AnswerEntry{UserID: user.ID, QuestionID: question.ID, AnswerID: answer.ID}
-	if err := InsertOrUpdateDB(answerEntry, answerEntry); err != nil {
+	if err := db.SaveAnswerEntry(answerEntry); err != nil {
 		resp.Text = fmt.Sprintf("Error: Can't add your answers: %v", err)
 		return resp
 	}
+	checkAchievements(TriggerAnswer, user.ID)
 	resp.Text = fmt.Sprintf("Answer Added.\n%s %s", question.Sentence, answer.Sentence)
 	return resp
 }
@@ -203,24 +195,34 @@ func slackCommandTVStatus(req *SlackCommandRequest, user *User) *SlackCommandRes
 		resp.Text = fmt.Sprintf("Error: Can't get answers: %v", err)
 		return resp
 	}
-	topUsers, err := GetUsersTop(6)
+	// The leaderboard is the one of the season, or of the week without season.
+	title, period := "week", PeriodWeek
+	if _, err := db.GetCurrentSeason(); err == nil {
+		title, period = "season", PeriodSeason
+	}
+	from, err := leaderboardStart(db, period, time.Now())
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't get top users: %v", err)
+		return resp
+	}
+	topUsers, err := GetLeaderboard(db, from, time.Time{}, leaderboardSize)
 	if err != nil {
 		resp.Text = fmt.Sprintf("Error: Can't get top users: %v", err)
 		return resp
 	}
+	topTeams, err := GetTeamsLeaderboard(db, from, ScoringTotal, leaderboardSize)
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't get top teams: %v", err)
+		return resp
+	}
 	buff := &bytes.Buffer{}
 	fmt.Fprintf(buff, "Question from %s %s:\n%s\n", questionUser.FirstName, questionUser.LastName, question.Sentence)
-	lastAnswerIndex := len(answers) - 1
-	for i, answer := range answers {
-		fmt.Fprintf(buff, "%d. %s", i+1, answer.Sentence)
-		if i < lastAnswerIndex {
-			buff.WriteString(", ")
-		}
-	}
-	buff.WriteString("\n\nTop:\n")
+	buff.WriteString(formatAnswers(answers))
+	fmt.Fprintf(buff, "\n\nTop of the %s:\n", title)
 	for _, user := range topUsers {
-		fmt.Fprintf(buff, "%s %s: %v points\n", user.FirstName, user.LastName, user.Points)
+		fmt.Fprintf(buff, "%d. %s %s: %v points\n", user.Rank, user.FirstName, user.LastName, user.Points)
 	}
+	buff.WriteString(formatTeamsTop(topTeams))
 	resp.Text = buff.String()
 	return resp
 }
@@ -236,10 +238,12 @@ func slackCommandTVImage(req *SlackCommandRequest, user *User) *SlackCommandResp
 		resp.Text = "Error: Invalid image URL"
 		return resp
 	}
-	if err := db.Create(&Image{URL: urlStr, UserID: user.ID}).Error; err != nil {
+	image := &Image{URL: urlStr, UserID: user.ID}
+	if err := db.CreateImage(image); err != nil {
 		resp.Text = fmt.Sprintf("Error: Can't add image to the database: %v", err)
 		return resp
 	}
+	events.Publish(EventImageCreated, image)
 	resp.Text = "Image added successfully!"
 	return resp
 }
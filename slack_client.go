@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var slackClient SlackClient = newSlackWebClient(slackURL, slackAPIToken)
+
+// SlackClient calls the slack Web API.
+type SlackClient interface {
+	// GetUserInfo calls users.info.
+	GetUserInfo(userID string) (*SlackUser, error)
+	// GetUsergroupMembers calls usergroups.users.list and returns the user ids.
+	GetUsergroupMembers(usergroupID string) ([]string, error)
+	// PostMessage calls chat.postMessage and sets the TS of the message.
+	PostMessage(msg *SlackMessage) error
+	// UpdateMessage calls chat.update on the message with the same TS.
+	UpdateMessage(msg *SlackMessage) error
+	// Respond posts the response to the response_url of a command or an interaction.
+	Respond(responseURL string, resp *SlackCommandResponse) error
+}
+
+// SlackMessage contains the data of a message posted with the Web API.
+type SlackMessage struct {
+	Channel string       `json:"channel"`
+	TS      string       `json:"ts,omitempty"`
+	Text    string       `json:"text"`
+	Blocks  []SlackBlock `json:"blocks,omitempty"`
+}
+
+// SlackAPIError is the error returned by a Web API method.
+type SlackAPIError struct {
+	Method string
+	Code   string
+}
+
+func (e *SlackAPIError) Error() string {
+	return fmt.Sprintf("slack %s: %s", e.Method, e.Code)
+}
+
+// slackWebClient is the SlackClient calling slack over HTTP.
+// Calls time out after timeout and are retried up to maxRetries times
+// with an exponential backoff, or after the Retry-After delay when rate limited.
+type slackWebClient struct {
+	url        string
+	token      string
+	httpClient *http.Client
+	timeout    time.Duration
+	maxRetries int
+	backoff    time.Duration
+}
+
+// newSlackWebClient creates a client for the Web API at url, authenticated with token.
+func newSlackWebClient(url, token string) *slackWebClient {
+	return &slackWebClient{
+		url:        url,
+		token:      token,
+		httpClient: &http.Client{},
+		timeout:    5 * time.Second,
+		maxRetries: 3,
+		backoff:    500 * time.Millisecond,
+	}
+}
+
+func (c *slackWebClient) GetUserInfo(userID string) (*SlackUser, error) {
+	respData := struct {
+		User SlackUser `json:"user"`
+	}{}
+	query := url.Values{"user": {userID}}
+	if err := c.call("GET", "users.info?"+query.Encode(), nil, &respData); err != nil {
+		return nil, err
+	}
+	return &respData.User, nil
+}
+
+func (c *slackWebClient) GetUsergroupMembers(usergroupID string) ([]string, error) {
+	respData := struct {
+		Users []string `json:"users"`
+	}{}
+	query := url.Values{"usergroup": {usergroupID}}
+	if err := c.call("GET", "usergroups.users.list?"+query.Encode(), nil, &respData); err != nil {
+		return nil, err
+	}
+	return respData.Users, nil
+}
+
+func (c *slackWebClient) PostMessage(msg *SlackMessage) error {
+	respData := struct {
+		TS string `json:"ts"`
+	}{}
+	if err := c.call("POST", "chat.postMessage", msg, &respData); err != nil {
+		return err
+	}
+	msg.TS = respData.TS
+	return nil
+}
+
+func (c *slackWebClient) UpdateMessage(msg *SlackMessage) error {
+	return c.call("POST", "chat.update", msg, &struct{}{})
+}
+
+func (c *slackWebClient) Respond(responseURL string, resp *SlackCommandResponse) error {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = c.do("POST", responseURL, body, false)
+	return err
+}
+
+// call calls a Web API method and decodes the response in out.
+// in is sent as JSON, unless it's nil.
+func (c *slackWebClient) call(httpMethod, method string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	data, err := c.do(httpMethod, c.url+"/api/"+method, body, true)
+	if err != nil {
+		return err
+	}
+	status := struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return err
+	}
+	if !status.OK {
+		return &SlackAPIError{Method: method, Code: status.Error}
+	}
+	return json.Unmarshal(data, out)
+}
+
+// do sends the request, retrying on network errors, server errors and rate limits,
+// and returns the body of the response.
+func (c *slackWebClient) do(httpMethod, urlStr string, body []byte, auth bool) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		data, retryAfter, err := c.doOnce(httpMethod, urlStr, body, auth)
+		if err == nil {
+			return data, nil
+		}
+		lastErr = err
+		if retryAfter < 0 || attempt >= c.maxRetries {
+			break
+		}
+		if retryAfter == 0 {
+			retryAfter = c.backoff << uint(attempt)
+		}
+		time.Sleep(retryAfter)
+	}
+	return nil, lastErr
+}
+
+// doOnce sends the request once. retryAfter is negative when the request
+// must not be retried, or the delay asked by slack.
+func (c *slackWebClient) doOnce(httpMethod, urlStr string, body []byte, auth bool) (data []byte, retryAfter time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	req, err := http.NewRequest(httpMethod, urlStr, bytes.NewReader(body))
+	if err != nil {
+		return nil, -1, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set(ContentType, ContentJSON)
+	}
+	if auth {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		err = fmt.Errorf("slack rate limited %s", req.URL.Path)
+		if sec, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+			return nil, time.Duration(sec) * time.Second, err
+		}
+		return nil, 0, err
+	case resp.StatusCode >= 500:
+		return nil, 0, fmt.Errorf("slack %s: %s", req.URL.Path, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, -1, fmt.Errorf("slack %s: %s: %s", req.URL.Path, resp.Status, data)
+	}
+	return data, 0, nil
+}
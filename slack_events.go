@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// slackMessagesChannel restricts the messages added from the events to one channel.
+	slackMessagesChannel = os.Getenv("SLACK_MESSAGES_CHANNEL")
+
+	slackEventIDs = newEventIDSet(time.Hour)
+
+	slackEventFunc = map[string]func(event json.RawMessage) error{
+		"message":                 slackEventMessage,
+		"user_change":             slackEventUserChange,
+		"subteam_members_changed": slackEventSubteamMembersChanged,
+	}
+)
+
+// SlackEventRequest contains the data of slack Events API request.
+type SlackEventRequest struct {
+	Token     string          `json:"token"`
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// SlackEvent contains the fields common to every slack event.
+type SlackEvent struct {
+	Type string `json:"type"`
+}
+
+// SlackMessageEvent contains the data of slack message event.
+type SlackMessageEvent struct {
+	Subtype   string             `json:"subtype"`
+	Channel   string             `json:"channel"`
+	User      string             `json:"user"`
+	BotID     string             `json:"bot_id"`
+	Text      string             `json:"text"`
+	TS        string             `json:"ts"`
+	DeletedTS string             `json:"deleted_ts"`
+	Message   *SlackMessageEvent `json:"message"`
+}
+
+// slackEvents handles the requests of the slack Events API.
+func slackEvents(w http.ResponseWriter, r *http.Request) {
+	var req SlackEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	switch req.Type {
+	case "url_verification":
+		renderJSON(w, http.StatusOK, map[string]string{"challenge": req.Challenge})
+		return
+	case "event_callback":
+	default:
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	var event SlackEvent
+	if err := json.Unmarshal(req.Event, &event); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	eventFunc, ok := slackEventFunc[event.Type]
+	// The events without id can't be told apart, so they aren't deduplicated.
+	if !ok || (req.EventID != "" && !slackEventIDs.Add(req.EventID)) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := eventFunc(req.Event); err != nil {
+		// Let slack retry the event.
+		slackEventIDs.Remove(req.EventID)
+		log.WithFields(log.Fields{
+			"event_id": req.EventID,
+			"type":     event.Type,
+			"err":      err,
+		}).Error("Can't handle slack event")
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// slackEventMessage adds, updates or deletes a message of the wall.
+func slackEventMessage(data json.RawMessage) error {
+	var event SlackMessageEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	if slackMessagesChannel != "" && event.Channel != slackMessagesChannel {
+		return nil
+	}
+	switch event.Subtype {
+	case "", "me_message", "thread_broadcast":
+		if event.BotID != "" || event.User == "" {
+			return nil
+		}
+		return AddSlackMessage(event.User, event.Channel, event.TS, event.Text)
+	case "message_changed":
+		if event.Message == nil {
+			return nil
+		}
+		message, err := db.GetMessageBySlackTS(event.Channel, event.Message.TS)
+		if err != nil {
+			return nil
+		}
+		message.Message = event.Message.Text
+		if err := db.SaveMessage(message); err != nil {
+			return err
+		}
+		events.Publish(EventMessageUpdated, message)
+		return nil
+	case "message_deleted":
+		message, err := db.GetMessageBySlackTS(event.Channel, event.DeletedTS)
+		if err != nil {
+			return nil
+		}
+		if err := db.DeleteMessage(message.ID); err != nil {
+			return err
+		}
+		events.Publish(EventMessageDeleted, message)
+		return nil
+	}
+	return nil
+}
+
+// SlackUserChangeEvent contains the data of slack user_change event.
+type SlackUserChangeEvent struct {
+	User SlackUser `json:"user"`
+}
+
+// slackEventUserChange updates the profile of a known user.
+func slackEventUserChange(data json.RawMessage) error {
+	var event SlackUserChangeEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	if _, err := db.GetUserBySlackID(event.User.ID); err != nil {
+		// Only the users who used the TV are stored.
+		return nil
+	}
+	return userCache.Save(&event.User)
+}
+
+// eventIDSet remembers the event ids seen for a while,
+// so that the events retried by slack are handled once.
+type eventIDSet struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+}
+
+func newEventIDSet(ttl time.Duration) *eventIDSet {
+	return &eventIDSet{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// Add adds the id to the set. It returns false if the id was already in the set.
+func (s *eventIDSet) Add(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for seenID, seenAt := range s.seen {
+		if now.Sub(seenAt) > s.ttl {
+			delete(s.seen, seenID)
+		}
+	}
+	if _, ok := s.seen[id]; ok {
+		return false
+	}
+	s.seen[id] = now
+	return true
+}
+
+// Remove removes the id from the set.
+func (s *eventIDSet) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.seen, id)
+}
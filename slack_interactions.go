@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// answerActionPrefix prefixes the action_id of the answer buttons.
+	answerActionPrefix = "answer:"
+)
+
+var (
+	errQuestionOver  = errors.New("This question is over")
+	errInvalidAnswer = errors.New("Invalid answer")
+)
+
+// SlackBlock contains the data of a Block Kit layout block.
+type SlackBlock struct {
+	Type     string         `json:"type"`
+	BlockID  string         `json:"block_id,omitempty"`
+	Text     *SlackText     `json:"text,omitempty"`
+	Elements []SlackElement `json:"elements,omitempty"`
+}
+
+// SlackText contains the data of a Block Kit text object.
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SlackElement contains the data of a Block Kit interactive element.
+type SlackElement struct {
+	Type     string     `json:"type"`
+	Text     *SlackText `json:"text,omitempty"`
+	ActionID string     `json:"action_id,omitempty"`
+	Value    string     `json:"value,omitempty"`
+}
+
+// SlackInteractionRequest contains the data of slack interaction request.
+type SlackInteractionRequest struct {
+	Payload string `schema:"payload"`
+}
+
+// SlackInteraction contains the payload of slack interaction request.
+type SlackInteraction struct {
+	Token       string        `json:"token"`
+	Type        string        `json:"type"`
+	ResponseURL string        `json:"response_url"`
+	User        SlackUser     `json:"user"`
+	Actions     []SlackAction `json:"actions"`
+}
+
+// SlackAction contains the data of an action of slack interaction.
+type SlackAction struct {
+	ActionID string `json:"action_id"`
+	BlockID  string `json:"block_id"`
+	Value    string `json:"value"`
+}
+
+// slackCommandTVQuiz posts the current question with a button per answer in the channel.
+func slackCommandTVQuiz(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	question, err := GetCurrentQuestion()
+	if err != nil {
+		return &SlackCommandResponse{Text: fmt.Sprintf("Error: Can't get current question: %v", err)}
+	}
+	resp, err := getQuestionMessage(question)
+	if err != nil {
+		return &SlackCommandResponse{Text: fmt.Sprintf("Error: %v", err)}
+	}
+	resp.ResponseType = "in_channel"
+	return resp
+}
+
+// getQuestionMessage returns the message showing the question with a button per answer.
+func getQuestionMessage(question *Question) (*SlackCommandResponse, error) {
+	questionUser, err := GetUser(question.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("Can't get user associated to the question: %v", err)
+	}
+	answers, err := GetAnswersByQuestionID(question.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Can't get answers: %v", err)
+	}
+	count, err := db.CountAnswerEntries(question.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Can't count answers: %v", err)
+	}
+	text := fmt.Sprintf("Question from %s %s:\n%s", questionUser.FirstName, questionUser.LastName, question.Sentence)
+	buttons := make([]SlackElement, len(answers))
+	for i, answer := range answers {
+		buttons[i] = SlackElement{
+			Type:     "button",
+			Text:     &SlackText{Type: "plain_text", Text: fmt.Sprintf("%d. %s", i+1, answer.Sentence)},
+			ActionID: fmt.Sprintf("%s%d", answerActionPrefix, i+1),
+			Value:    fmt.Sprintf("%d:%d", question.ID, answer.ID),
+		}
+	}
+	blocks := []SlackBlock{
+		{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: text}},
+		{Type: "actions", BlockID: fmt.Sprintf("question:%d", question.ID), Elements: buttons},
+	}
+	if count > 0 {
+		countText := fmt.Sprintf("%d people answered", count)
+		if count == 1 {
+			countText = "1 person answered"
+		}
+		blocks = append(blocks, SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: countText}})
+	}
+	return &SlackCommandResponse{Text: text, Blocks: blocks}, nil
+}
+
+// slackInteractions handles the clicks on the answer buttons.
+func slackInteractions(w http.ResponseWriter, r *http.Request) {
+	var req SlackInteractionRequest
+	if err := decodeRequestForm(r, &req); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	var interaction SlackInteraction
+	if err := json.Unmarshal([]byte(req.Payload), &interaction); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	if interaction.Type != "block_actions" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	var responses []*SlackCommandResponse
+	for _, action := range interaction.Actions {
+		if !strings.HasPrefix(action.ActionID, answerActionPrefix) {
+			continue
+		}
+		slackResp, err := answerFromAction(&interaction, &action)
+		if err != nil {
+			slackResp = &SlackCommandResponse{ResponseType: "ephemeral", Text: fmt.Sprintf("Error: %v", err)}
+		}
+		responses = append(responses, slackResp)
+	}
+	// Slack wants the interaction acknowledged within 3 seconds, before the responses are posted.
+	w.WriteHeader(http.StatusOK)
+	if len(responses) > 0 {
+		go respondInteraction(interaction.ResponseURL, responses)
+	}
+}
+
+// respondInteraction posts the responses to the interaction in order.
+func respondInteraction(responseURL string, responses []*SlackCommandResponse) {
+	for _, slackResp := range responses {
+		if err := slackClient.Respond(responseURL, slackResp); err != nil {
+			log.WithField("err", err).Error("Can't reply to slack interaction")
+		}
+	}
+}
+
+// answerFromAction records the answer of the clicked button, and returns
+// the question message updated with the new number of answers.
+func answerFromAction(interaction *SlackInteraction, action *SlackAction) (*SlackCommandResponse, error) {
+	values := strings.SplitN(action.Value, ":", 2)
+	if len(values) != 2 {
+		return nil, errInvalidAnswer
+	}
+	questionID, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return nil, errInvalidAnswer
+	}
+	answerID, err := strconv.ParseUint(values[1], 10, 64)
+	if err != nil {
+		return nil, errInvalidAnswer
+	}
+	question, err := GetCurrentQuestion()
+	if err != nil || question.ID != uint(questionID) || !question.EndedAt.IsZero() {
+		return nil, errQuestionOver
+	}
+	if !hasAnswer(question, uint(answerID)) {
+		return nil, errInvalidAnswer
+	}
+	user, err := GetUserBySlackID(interaction.User.ID)
+	if err != nil {
+		return nil, err
+	}
+	answerEntry := &AnswerEntry{UserID: user.ID, QuestionID: question.ID, AnswerID: uint(answerID)}
+	if err := db.SaveAnswerEntry(answerEntry); err != nil {
+		return nil, fmt.Errorf("Can't add your answers: %v", err)
+	}
+	checkAchievements(TriggerAnswer, user.ID)
+	slackResp, err := getQuestionMessage(question)
+	if err != nil {
+		return nil, err
+	}
+	slackResp.ReplaceOriginal = true
+	return slackResp, nil
+}
+
+// hasAnswer reports whether answerID is one of the answers of the question.
+func hasAnswer(question *Question, answerID uint) bool {
+	answers, err := GetAnswersByQuestionID(question.ID)
+	if err != nil {
+		return false
+	}
+	for _, answer := range answers {
+		if answer.ID == answerID {
+			return true
+		}
+	}
+	return false
+}
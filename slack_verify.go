@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+)
+
+var (
+	slackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+	// slackLegacyTokens enables the deprecated verification token check
+	// for the requests which aren't signed.
+	slackLegacyTokens = os.Getenv("SLACK_LEGACY_TOKENS") == "true"
+	// slackSignatureMaxAge is the maximum age of a signed request, to reject replays.
+	slackSignatureMaxAge = 5 * time.Minute
+
+	errInvalidSignature = errors.New("Invalid signature")
+	errInvalidSignedAt  = errors.New("Invalid or expired request timestamp")
+)
+
+// verifySlackRequest returns a middleware checking that the request comes from Slack.
+// The request must be signed with the signing secret, or carry token
+// if the legacy tokens are enabled. The body stays readable by the next handlers.
+func verifySlackRequest(token string) martini.Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if r.Header.Get("X-Slack-Signature") == "" && slackLegacyTokens {
+			if token == "" || legacySlackToken(r, body) != token {
+				renderJSON(w, http.StatusUnauthorized, errInvalidToken)
+			}
+			return
+		}
+		if err := checkSlackSignature(r.Header, body, time.Now()); err != nil {
+			log.WithFields(log.Fields{
+				"path": r.URL.Path,
+				"err":  err,
+			}).Info("Invalid slack request signature")
+			renderJSON(w, http.StatusUnauthorized, Error{err.Error()})
+		}
+	}
+}
+
+// checkSlackSignature checks the signature of a request body sent at now.
+func checkSlackSignature(header http.Header, body []byte, now time.Time) error {
+	if slackSigningSecret == "" {
+		return errInvalidSignature
+	}
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errInvalidSignedAt
+	}
+	if math.Abs(now.Sub(time.Unix(sec, 0)).Seconds()) > slackSignatureMaxAge.Seconds() {
+		return errInvalidSignedAt
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(header.Get("X-Slack-Signature"), "v0="))
+	if err != nil {
+		return errInvalidSignature
+	}
+	if !hmac.Equal(signature, slackSignature(timestamp, body)) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// slackSignature returns the HMAC-SHA256 of a request body sent at timestamp.
+func slackSignature(timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(slackSigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// legacySlackToken returns the verification token of a request.
+// The token is in the form, in the JSON body of the events or in the
+// JSON payload field of the interactions.
+func legacySlackToken(r *http.Request, body []byte) string {
+	var data struct {
+		Token string `json:"token"`
+	}
+	if strings.HasPrefix(r.Header.Get(ContentType), "application/json") {
+		json.Unmarshal(body, &data)
+		return data.Token
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	if payload := form.Get("payload"); payload != "" {
+		json.Unmarshal([]byte(payload), &data)
+		return data.Token
+	}
+	return form.Get("token")
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Scorings of the teams leaderboard.
+const (
+	// ScoringTotal ranks the teams by the points of their members.
+	ScoringTotal = "total"
+	// ScoringPerCapita ranks the teams by the points of their members divided by their number.
+	ScoringPerCapita = "per_capita"
+)
+
+const maxTeamNameLength = 32
+
+var (
+	// teamProfileField is the id of the custom field of the slack profiles naming the team, e.g. Xf0DEPT.
+	teamProfileField = os.Getenv("TEAM_PROFILE_FIELD")
+
+	slackUsergroupRegexp = regexp.MustCompile(`^<!subteam\^([A-Z0-9]+)(\|[^>]*)?>$`)
+
+	errInvalidScoring  = errors.New("Invalid scoring, must be total or per_capita")
+	errInvalidTeamName = fmt.Errorf("Invalid team name, maximum %d characters", maxTeamNameLength)
+)
+
+// Team is a group of users competing together.
+// The members of a team synced with a slack user group are the ones of the group.
+type Team struct {
+	gorm.Model
+	Name             string `sql:"unique"`
+	SlackUsergroupID string
+}
+
+// TeamStanding is a team with its rank in the teams leaderboard.
+// The Points are the ones of its members during the period.
+type TeamStanding struct {
+	Team      Team
+	Rank      int
+	Points    uint
+	Members   int
+	PerCapita float64
+}
+
+// GetTeamsTopRequest contains the data of get teams top request.
+type GetTeamsTopRequest struct {
+	Count   int    `schema:"count"`
+	Period  string `schema:"period"`
+	Scoring string `schema:"scoring"`
+}
+
+// SlackSubteamMembersChangedEvent contains the data of slack subteam_members_changed event.
+type SlackSubteamMembersChangedEvent struct {
+	SubteamID    string   `json:"subteam_id"`
+	AddedUsers   []string `json:"added_users"`
+	RemovedUsers []string `json:"removed_users"`
+}
+
+// getTeamsTop returns the teams top, by total points of the current season by default.
+func getTeamsTop(w http.ResponseWriter, r *http.Request) {
+	req := GetTeamsTopRequest{
+		Count:   leaderboardSize,
+		Period:  PeriodSeason,
+		Scoring: ScoringTotal,
+	}
+	if err := decodeRequestQuery(r, &req); err != nil {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	from, err := leaderboardStart(db, req.Period, time.Now())
+	if err == errInvalidPeriod {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	} else if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	standings, err := GetTeamsLeaderboard(db, from, req.Scoring, req.Count)
+	switch err {
+	case nil:
+		renderJSON(w, http.StatusOK, standings)
+	case errInvalidScoring:
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+	default:
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+	}
+}
+
+// GetTeamsLeaderboard returns the count first teams by the points scored by their members
+// since the time, as GetLeaderboard, or every team if count is 0. Ties go to the smallest team.
+func GetTeamsLeaderboard(tx Store, from time.Time, scoring string, count int) ([]TeamStanding, error) {
+	if scoring != ScoringTotal && scoring != ScoringPerCapita {
+		return nil, errInvalidScoring
+	}
+	teams, err := tx.GetTeams()
+	if err != nil {
+		return nil, err
+	}
+	users, err := tx.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	standings, err := GetLeaderboard(tx, from, time.Time{}, 0)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[uint]int)
+	for _, user := range users {
+		members[user.TeamID]++
+	}
+	points := make(map[uint]uint)
+	for _, standing := range standings {
+		points[standing.TeamID] += standing.Points
+	}
+	teamStandings := make([]TeamStanding, 0, len(teams))
+	for _, team := range teams {
+		standing := TeamStanding{Team: team, Points: points[team.ID], Members: members[team.ID]}
+		if standing.Members > 0 {
+			standing.PerCapita = math.Round(float64(standing.Points)*10/float64(standing.Members)) / 10
+		}
+		teamStandings = append(teamStandings, standing)
+	}
+	sort.SliceStable(teamStandings, func(i, j int) bool {
+		a, b := &teamStandings[i], &teamStandings[j]
+		if scoring == ScoringPerCapita && a.PerCapita != b.PerCapita {
+			return a.PerCapita > b.PerCapita
+		}
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		return a.Members < b.Members
+	})
+	if count > 0 && len(teamStandings) > count {
+		teamStandings = teamStandings[:count]
+	}
+	for i := range teamStandings {
+		teamStandings[i].Rank = i + 1
+	}
+	return teamStandings, nil
+}
+
+// getOrCreateTeam returns the team with the name, created if it doesn't exist.
+func getOrCreateTeam(tx Store, name string) (*Team, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxTeamNameLength {
+		return nil, errInvalidTeamName
+	}
+	team, err := tx.GetTeamByName(name)
+	if err == gorm.ErrRecordNotFound {
+		team = &Team{Name: name}
+		err = tx.CreateTeam(team)
+	}
+	return team, err
+}
+
+// assignTeam puts the user in the team with the name, or in no team if the name is empty.
+func assignTeam(s Store, userID uint, name string) (*Team, error) {
+	var team *Team
+	err := s.Transaction(func(tx Store) error {
+		if strings.TrimSpace(name) == "" {
+			return tx.SetUserTeam(userID, 0)
+		}
+		var err error
+		if team, err = getOrCreateTeam(tx, name); err != nil {
+			return err
+		}
+		return tx.SetUserTeam(userID, team.ID)
+	})
+	return team, err
+}
+
+// syncTeam sets the members of the team to the users of its slack user group.
+// The members who didn't use the TV yet are created without profile.
+func syncTeam(s Store, team *Team) error {
+	slackIDs, err := slackClient.GetUsergroupMembers(team.SlackUsergroupID)
+	if err != nil {
+		return err
+	}
+	return s.Transaction(func(tx Store) error {
+		members := make(map[uint]bool)
+		for _, slackID := range slackIDs {
+			user, err := getOrCreateMember(tx, slackID)
+			if err != nil {
+				return err
+			}
+			members[user.ID] = true
+			if user.TeamID != team.ID {
+				if err := tx.SetUserTeam(user.ID, team.ID); err != nil {
+					return err
+				}
+			}
+		}
+		users, err := tx.GetUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if user.TeamID == team.ID && !members[user.ID] {
+				if err := tx.SetUserTeam(user.ID, 0); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// getOrCreateMember returns the user with the Slack ID, created without profile if it doesn't exist.
+// The profile is fetched from slack when the user is first used.
+func getOrCreateMember(tx Store, slackID string) (*User, error) {
+	user, err := tx.GetUserBySlackID(slackID)
+	if err == gorm.ErrRecordNotFound {
+		user = &User{SlackID: slackID}
+		err = tx.SaveUserProfile(user)
+	}
+	return user, err
+}
+
+// syncProfileTeam puts the user in the team named by the TEAM_PROFILE_FIELD of the profile.
+// The users of the teams synced with a user group are left in them.
+func syncProfileTeam(s Store, user *User, profile *SlackProfile) error {
+	if teamProfileField == "" || profile.Fields == nil {
+		return nil
+	}
+	if user.TeamID != 0 {
+		team, err := s.GetTeam(user.TeamID)
+		if err == nil && team.SlackUsergroupID != "" {
+			return nil
+		}
+	}
+	field := profile.Fields[teamProfileField]
+	if user.TeamID == 0 && field.Value == "" {
+		return nil
+	}
+	team, err := assignTeam(s, user.ID, field.Value)
+	if err != nil {
+		return err
+	}
+	user.TeamID = 0
+	if team != nil {
+		user.TeamID = team.ID
+	}
+	return nil
+}
+
+// slackEventSubteamMembersChanged updates the members of the team synced with the user group.
+func slackEventSubteamMembersChanged(data json.RawMessage) error {
+	var event SlackSubteamMembersChangedEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	team, err := db.GetTeamBySlackUsergroupID(event.SubteamID)
+	if err != nil {
+		// Only the user groups of the teams are synced.
+		return nil
+	}
+	return db.Transaction(func(tx Store) error {
+		for _, slackID := range event.AddedUsers {
+			user, err := getOrCreateMember(tx, slackID)
+			if err != nil {
+				return err
+			}
+			if err := tx.SetUserTeam(user.ID, team.ID); err != nil {
+				return err
+			}
+		}
+		for _, slackID := range event.RemovedUsers {
+			user, err := tx.GetUserBySlackID(slackID)
+			if err != nil || user.TeamID != team.ID {
+				continue
+			}
+			if err := tx.SetUserTeam(user.ID, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// slackCommandTVTeam puts a user in a team, e.g. "team @john Sales", or in none with "team @john none".
+func slackCommandTVTeam(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	resp := &SlackCommandResponse{}
+	args := strings.SplitN(req.Text, " ", 3)
+	if len(args) != 3 {
+		resp.Text = commandTVUsage
+		return resp
+	}
+	slackID := args[1]
+	if match := slackMentionRegexp.FindStringSubmatch(slackID); match != nil {
+		slackID = match[1]
+	}
+	name := strings.TrimSpace(args[2])
+	if name == "none" {
+		name = ""
+	}
+	target, err := GetUserBySlackID(slackID)
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't get user: %v", err)
+		return resp
+	}
+	team, err := assignTeam(db, target.ID, name)
+	if err != nil {
+		resp.Text = fmt.Sprintf("Error: Can't set team: %v", err)
+		return resp
+	}
+	if team == nil {
+		resp.Text = fmt.Sprintf("%s %s is in no team.", target.FirstName, target.LastName)
+		return resp
+	}
+	resp.Text = fmt.Sprintf("%s %s is in %s.", target.FirstName, target.LastName, team.Name)
+	return resp
+}
+
+// slackCommandTVTeamSync syncs a team with a slack user group, e.g. "team-sync Sales @sales",
+// or every synced team without argument.
+func slackCommandTVTeamSync(req *SlackCommandRequest, user *User) *SlackCommandResponse {
+	resp := &SlackCommandResponse{}
+	args := strings.Fields(req.Text)
+	var teams []Team
+	switch len(args) {
+	case 1:
+		all, err := db.GetTeams()
+		if err != nil {
+			resp.Text = fmt.Sprintf("Error: Can't get teams: %v", err)
+			return resp
+		}
+		for _, team := range all {
+			if team.SlackUsergroupID != "" {
+				teams = append(teams, team)
+			}
+		}
+	case 3:
+		usergroupID := args[2]
+		if match := slackUsergroupRegexp.FindStringSubmatch(usergroupID); match != nil {
+			usergroupID = match[1]
+		}
+		team, err := getOrCreateTeam(db, args[1])
+		if err != nil {
+			resp.Text = fmt.Sprintf("Error: Can't get team: %v", err)
+			return resp
+		}
+		team.SlackUsergroupID = usergroupID
+		if err := db.SaveTeam(team); err != nil {
+			resp.Text = fmt.Sprintf("Error: Can't save team: %v", err)
+			return resp
+		}
+		teams = append(teams, *team)
+	default:
+		resp.Text = commandTVUsage
+		return resp
+	}
+	for i := range teams {
+		if err := syncTeam(db, &teams[i]); err != nil {
+			resp.Text = fmt.Sprintf("Error: Can't sync team %s: %v", teams[i].Name, err)
+			return resp
+		}
+	}
+	resp.Text = fmt.Sprintf("%d teams synced.", len(teams))
+	return resp
+}
+
+// formatTeamsTop returns the teams leaderboard of the status, or nothing without team.
+func formatTeamsTop(teams []TeamStanding) string {
+	if len(teams) == 0 {
+		return ""
+	}
+	buff := &bytes.Buffer{}
+	buff.WriteString("\nTeams:\n")
+	for _, team := range teams {
+		fmt.Fprintf(buff, "%d. %s: %v points, %v per member\n", team.Rank, team.Team.Name, team.Points, team.PerCapita)
+	}
+	return buff.String()
+}
@@ -1,16 +1,17 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-martini/martini"
 	"github.com/jinzhu/gorm"
 )
 
+// leaderboardSize is the default number of users in the top.
+const leaderboardSize = 6
+
 // User contains information about a user.
 type User struct {
 	gorm.Model
@@ -19,6 +20,10 @@ type User struct {
 	LastName  string
 	ImageURL  string
 	Points    uint
+	Streak    uint
+	TeamID    uint
+	Role      string    `json:"-"`
+	SyncedAt  time.Time `json:"-"`
 }
 
 // SlackUser contains the data of slack command request.
@@ -28,10 +33,17 @@ type SlackUser struct {
 }
 
 // SlackProfile contains the data contained in SlackUser structure.
+// Fields are the custom fields of the profile by id.
 type SlackProfile struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	ImageURL  string `json:"image_192"`
+	FirstName string                       `json:"first_name"`
+	LastName  string                       `json:"last_name"`
+	ImageURL  string                       `json:"image_192"`
+	Fields    map[string]SlackProfileField `json:"fields"`
+}
+
+// SlackProfileField contains the data of a custom field of SlackProfile.
+type SlackProfileField struct {
+	Value string `json:"value"`
 }
 
 // AddUserRequest contains the data of add user request.
@@ -41,8 +53,11 @@ type AddUserRequest struct {
 }
 
 // GetUsersTopRequest contains the data of get users top request.
+// The points of a category are the right answers to its questions, of every period.
 type GetUsersTopRequest struct {
-	Count int `schema:"count"`
+	Count    int    `schema:"count"`
+	Category string `schema:"category"`
+	Period   string `schema:"period"`
 }
 
 // getUser returns a user.
@@ -60,73 +75,71 @@ func getUser(w http.ResponseWriter, r *http.Request, params martini.Params) {
 	renderJSON(w, http.StatusOK, user)
 }
 
-// getUsersTop returns the users top by points.
+// getUsersTop returns the users top by points, of the current season by default.
 func getUsersTop(w http.ResponseWriter, r *http.Request) {
 	req := GetUsersTopRequest{
-		Count: 6,
+		Count: leaderboardSize,
 	}
 	if err := decodeRequestQuery(r, &req); err != nil {
 		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
 		return
 	}
-	users, err := GetUsersTop(req.Count)
+	period := req.Period
+	if period == "" {
+		period = PeriodSeason
+	}
+	from, err := leaderboardStart(db, period, time.Now())
+	if err == errInvalidPeriod {
+		renderJSON(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	} else if err != nil {
+		renderJSON(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	if req.Category != "" {
+		if req.Period != "" && req.Period != PeriodAll {
+			renderJSON(w, http.StatusBadRequest, Error{"The category leaderboard is of every period"})
+			return
+		}
+		users, err := db.GetUsersTopByCategory(normalizeCategory(req.Category), req.Count)
+		if err != nil {
+			renderJSON(w, http.StatusNotFound, Error{err.Error()})
+			return
+		}
+		renderJSON(w, http.StatusOK, newStandings(users))
+		return
+	}
+	standings, err := GetLeaderboard(db, from, time.Time{}, req.Count)
 	if err != nil {
 		renderJSON(w, http.StatusNotFound, Error{err.Error()})
 		return
 	}
-	renderJSON(w, http.StatusOK, users)
+	renderJSON(w, http.StatusOK, standings)
 }
 
 // GetUser returns the user associated to the id.
 func GetUser(id uint) (*User, error) {
-	user := &User{}
-	err := db.First(user, id).Error
-	return user, err
+	return db.GetUser(id)
 }
 
 // GetUserBySlackID returns the user associated to the SlackID.
 // if user doesn't exist yet, we create it.
 func GetUserBySlackID(id string) (*User, error) {
-	user, err := getUserFromSlack(id)
-	if err != nil {
-		return nil, err
-	}
-	if err := InsertOrUpdateDB(&User{SlackID: id}, user); err != nil {
-		return nil, err
-	}
-	return user, nil
+	return userCache.Get(id)
 }
 
 // GetUsersTop return the top users by points with maximum count users.
-func GetUsersTop(count int) (users []User, err error) {
-	err = db.Order("points desc").Limit(count).Find(&users).Error
-	return
+func GetUsersTop(count int) ([]User, error) {
+	return db.GetUsersTop(count)
 }
 
-// getUserFromSlack calls slackAPI to get user information and returns a user object.
-func getUserFromSlack(id string) (*User, error) {
-	reqURL := fmt.Sprintf("%s/api/users.info?token=%s&user=%s", slackURL, slackAPIToken, id)
-	resp, err := http.Get(reqURL)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	respData := struct {
-		OK    bool      `json:"ok"`
-		Error string    `json:"error,omitempty"`
-		User  SlackUser `json:"user,omitempty"`
-	}{}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		return nil, err
-	}
-	if !respData.OK {
-		return nil, errors.New(respData.Error)
-	}
+// newUserFromSlack returns a user with the profile of the slack user.
+func newUserFromSlack(slackUser *SlackUser) *User {
 	return &User{
-		SlackID:   respData.User.ID,
-		FirstName: respData.User.Profile.FirstName,
-		LastName:  respData.User.Profile.LastName,
-		ImageURL:  respData.User.Profile.ImageURL,
+		SlackID:   slackUser.ID,
+		FirstName: slackUser.Profile.FirstName,
+		LastName:  slackUser.Profile.LastName,
+		ImageURL:  slackUser.Profile.ImageURL,
 		Points:    0,
-	}, nil
+	}
 }
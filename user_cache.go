@@ -0,0 +1,149 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// userCache is the cache of the slack profiles, sized by loadConfig.
+var userCache = newUserProfileCache(time.Hour, 1024)
+
+// userProfile is the slack profile of a user, fetched at SyncedAt.
+type userProfile struct {
+	SlackID  string
+	Profile  SlackProfile
+	SyncedAt time.Time
+}
+
+// userProfileCache caches the slack profiles by SlackID, so that they aren't fetched
+// on every request. Only the profiles are cached, the users are always read from the
+// store. A profile older than ttl is refreshed in the background.
+type userProfileCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	size       int
+	lru        *list.List
+	entries    map[string]*list.Element
+	refreshing map[string]bool
+}
+
+func newUserProfileCache(ttl time.Duration, size int) *userProfileCache {
+	return &userProfileCache{
+		ttl:        ttl,
+		size:       size,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		refreshing: make(map[string]bool),
+	}
+}
+
+// Get returns the user associated to the SlackID.
+// The user is created from its slack profile if it doesn't exist yet.
+func (c *userProfileCache) Get(slackID string) (*User, error) {
+	user, err := db.GetUserBySlackID(slackID)
+	if err != nil || user.SyncedAt.IsZero() {
+		return c.refresh(slackID)
+	}
+	profile, ok := c.get(slackID)
+	if !ok {
+		// The profile saved in the users table, synced before the restart.
+		profile = &userProfile{
+			SlackID:  user.SlackID,
+			Profile:  SlackProfile{FirstName: user.FirstName, LastName: user.LastName, ImageURL: user.ImageURL},
+			SyncedAt: user.SyncedAt,
+		}
+		c.Put(profile)
+	}
+	c.refreshIfStale(profile)
+	return user, nil
+}
+
+// Put adds or replaces the profile in the cache.
+func (c *userProfileCache) Put(profile *userProfile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[profile.SlackID]; ok {
+		elem.Value = *profile
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[profile.SlackID] = c.lru.PushFront(*profile)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(userProfile).SlackID)
+	}
+}
+
+// Reset removes every profile from the cache.
+func (c *userProfileCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *userProfileCache) get(slackID string) (*userProfile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[slackID]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	profile := elem.Value.(userProfile)
+	return &profile, true
+}
+
+// refresh fetches the slack profile of the user, saves it and returns the user.
+func (c *userProfileCache) refresh(slackID string) (*User, error) {
+	slackUser, err := slackClient.GetUserInfo(slackID)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Save(slackUser); err != nil {
+		return nil, err
+	}
+	return db.GetUserBySlackID(slackID)
+}
+
+// Save saves the slack profile in the users table and in the cache.
+func (c *userProfileCache) Save(slackUser *SlackUser) error {
+	user := newUserFromSlack(slackUser)
+	user.SyncedAt = time.Now()
+	if err := db.SaveUserProfile(user); err != nil {
+		return err
+	}
+	if err := syncProfileTeam(db, user, &slackUser.Profile); err != nil {
+		return err
+	}
+	c.Put(&userProfile{SlackID: slackUser.ID, Profile: slackUser.Profile, SyncedAt: user.SyncedAt})
+	return nil
+}
+
+// refreshIfStale refreshes the profile in the background if it's too old.
+func (c *userProfileCache) refreshIfStale(profile *userProfile) {
+	if time.Since(profile.SyncedAt) < c.ttl {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing[profile.SlackID] {
+		return
+	}
+	c.refreshing[profile.SlackID] = true
+	go func(slackID string) {
+		if _, err := c.refresh(slackID); err != nil {
+			log.WithFields(log.Fields{
+				"slack_id": slackID,
+				"err":      err,
+			}).Error("Can't refresh slack user profile")
+		}
+		c.mu.Lock()
+		delete(c.refreshing, slackID)
+		c.mu.Unlock()
+	}(profile.SlackID)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+)
+
+// Topics a screen can subscribe to.
+const (
+	TopicMessages    = "messages"
+	TopicImages      = "images"
+	TopicQuestion    = "question"
+	TopicLeaderboard = "leaderboard"
+	TopicRotation    = "rotation"
+)
+
+var (
+	screens = newScreenHub()
+
+	// eventTopics maps the event types to the topics.
+	eventTopics = map[string]string{
+		EventMessageCreated:     TopicMessages,
+		EventMessageUpdated:     TopicMessages,
+		EventMessageDeleted:     TopicMessages,
+		EventImageCreated:       TopicImages,
+		EventImageDeleted:       TopicImages,
+		EventQuestionRotated:    TopicQuestion,
+		EventQuestionRevealed:   TopicQuestion,
+		EventLeaderboardChanged: TopicLeaderboard,
+		EventRotationPaused:     TopicRotation,
+		EventRotationResumed:    TopicRotation,
+	}
+
+	wsUpgrader = websocket.Upgrader{
+		// The screens aren't browsers running third party pages.
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+
+	wsWriteTimeout = 10 * time.Second
+	wsPingPeriod   = 30 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsSendBuffer   = 32
+)
+
+// ScreenRequest is a message sent by a screen.
+// Type is "subscribe" or "unsubscribe".
+type ScreenRequest struct {
+	Type   string   `json:"type"`
+	Topics []string `json:"topics"`
+}
+
+// ScreenMessage is a message sent to a screen.
+// Event is empty when Data is the current state of the topic, sent on subscribe.
+type ScreenMessage struct {
+	Topic string      `json:"topic"`
+	Event string      `json:"event,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// Screen is a display connected to the WebSocket endpoint.
+type Screen struct {
+	ID          uint64
+	Name        string
+	Addr        string
+	Topics      []string
+	ConnectedAt time.Time
+}
+
+// screenConn is the connection of a screen.
+type screenConn struct {
+	Screen
+	conn   *websocket.Conn
+	topics map[string]bool
+	send   chan ScreenMessage
+	done   chan struct{}
+	close  sync.Once
+}
+
+// screenHub tracks the connected screens and forwards them the events of their topics.
+type screenHub struct {
+	mu      sync.Mutex
+	lastID  uint64
+	screens map[uint64]*screenConn
+	running bool
+}
+
+func newScreenHub() *screenHub {
+	return &screenHub{screens: make(map[uint64]*screenConn)}
+}
+
+// Run forwards the events to the screens until the broker closes.
+func (h *screenHub) Run() {
+	h.mu.Lock()
+	if h.running {
+		h.mu.Unlock()
+		return
+	}
+	h.running = true
+	h.mu.Unlock()
+	for {
+		ch, _, _ := events.Subscribe(0)
+		for event := range ch {
+			topic, ok := eventTopics[event.Type]
+			if !ok {
+				continue
+			}
+			h.broadcast(ScreenMessage{Topic: topic, Event: event.Type, Data: event.Data})
+		}
+		// The hub was too slow, the screens may have missed events.
+		log.Warn("Screen hub dropped by the event broker")
+	}
+}
+
+// Screens returns the connected screens, ordered by id.
+func (h *screenHub) Screens() []Screen {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := make([]Screen, 0, len(h.screens))
+	for _, screen := range h.screens {
+		info := screen.Screen
+		info.Topics = screen.topicList()
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (h *screenHub) add(screen *screenConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	screen.ID = h.lastID
+	if screen.Name == "" {
+		screen.Name = "screen-" + strconv.FormatUint(screen.ID, 10)
+	}
+	h.screens[screen.ID] = screen
+}
+
+func (h *screenHub) remove(screen *screenConn) {
+	h.mu.Lock()
+	delete(h.screens, screen.ID)
+	h.mu.Unlock()
+	screen.Close()
+}
+
+// subscribe changes the topics of the screen.
+func (h *screenHub) subscribe(screen *screenConn, topics []string, subscribed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		if subscribed {
+			screen.topics[topic] = true
+		} else {
+			delete(screen.topics, topic)
+		}
+	}
+}
+
+// broadcast sends the message to the screens subscribed to its topic.
+// A screen too slow to receive it is disconnected.
+func (h *screenHub) broadcast(msg ScreenMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, screen := range h.screens {
+		if !screen.topics[msg.Topic] {
+			continue
+		}
+		if !screen.trySend(msg) {
+			log.WithFields(log.Fields{
+				"screen": screen.Name,
+				"addr":   screen.Addr,
+			}).Warn("Dropping slow screen")
+			delete(h.screens, id)
+			screen.Close()
+		}
+	}
+}
+
+// trySend queues the message without blocking. It returns false if the queue is full.
+func (s *screenConn) trySend(msg ScreenMessage) bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+	}
+	select {
+	case s.send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close disconnects the screen.
+func (s *screenConn) Close() {
+	s.close.Do(func() {
+		close(s.done)
+		s.conn.Close()
+	})
+}
+
+func (s *screenConn) topicList() []string {
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// getScreens returns the connected screens.
+func getScreens(w http.ResponseWriter, r *http.Request) {
+	renderJSON(w, http.StatusOK, screens.Screens())
+}
+
+// getWebSocket connects a screen. The screen is named by the screen query parameter.
+func getWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with the error.
+		return
+	}
+	screen := &screenConn{
+		Screen: Screen{
+			Name:        r.URL.Query().Get("screen"),
+			Addr:        r.RemoteAddr,
+			ConnectedAt: time.Now(),
+		},
+		conn:   conn,
+		topics: make(map[string]bool),
+		send:   make(chan ScreenMessage, wsSendBuffer),
+		done:   make(chan struct{}),
+	}
+	screens.add(screen)
+	defer screens.remove(screen)
+	go screen.writeLoop()
+	screen.readLoop()
+}
+
+// readLoop handles the requests of the screen until it disconnects.
+func (s *screenConn) readLoop() {
+	s.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	for {
+		var req ScreenRequest
+		if err := s.conn.ReadJSON(&req); err != nil {
+			return
+		}
+		switch req.Type {
+		case "subscribe":
+			screens.subscribe(s, req.Topics, true)
+			for _, topic := range req.Topics {
+				if !s.trySend(getTopicState(topic)) {
+					return
+				}
+			}
+		case "unsubscribe":
+			screens.subscribe(s, req.Topics, false)
+		default:
+			if !s.trySend(ScreenMessage{Error: "Invalid request type"}) {
+				return
+			}
+		}
+	}
+}
+
+// writeLoop sends the queued messages and the heartbeats until the screen is closed.
+func (s *screenConn) writeLoop() {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case msg := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := s.conn.WriteJSON(msg); err != nil {
+				s.Close()
+				return
+			}
+		case <-ping.C:
+			deadline := time.Now().Add(wsWriteTimeout)
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				s.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// getTopicState returns the current state of the topic,
+// rendered like the matching REST endpoint.
+func getTopicState(topic string) ScreenMessage {
+	msg := ScreenMessage{Topic: topic}
+	var err error
+	switch topic {
+	case TopicMessages:
+		msg.Data, err = db.GetMessages(0, 10)
+	case TopicImages:
+		msg.Data, err = GetLastImage()
+	case TopicQuestion:
+		var question *Question
+		if question, err = GetCurrentQuestion(); err == nil {
+			msg.Data, err = newCurrentQuestionAnswer(db, question)
+		}
+	case TopicLeaderboard:
+		msg.Data, err = GetSeasonLeaderboard(db, leaderboardSize)
+	case TopicRotation:
+		msg.Data = scheduler.State()
+	default:
+		msg.Error = "Invalid topic"
+		return msg
+	}
+	if err != nil {
+		msg.Data = nil
+		msg.Error = err.Error()
+	}
+	return msg
+}